@@ -0,0 +1,317 @@
+package sigma
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AggFunc is the aggregation function used in a rule condition after the pipe separator
+type AggFunc int
+
+const (
+	AggCount AggFunc = iota
+	AggSum
+	AggMin
+	AggMax
+	AggAvg
+)
+
+func (a AggFunc) String() string {
+	switch a {
+	case AggCount:
+		return "count"
+	case AggSum:
+		return "sum"
+	case AggMin:
+		return "min"
+	case AggMax:
+		return "max"
+	case AggAvg:
+		return "avg"
+	default:
+		return "Unk"
+	}
+}
+
+func newAggFunc(in string) (AggFunc, error) {
+	switch strings.ToLower(in) {
+	case "count":
+		return AggCount, nil
+	case "sum":
+		return AggSum, nil
+	case "min":
+		return AggMin, nil
+	case "max":
+		return AggMax, nil
+	case "avg":
+		return AggAvg, nil
+	default:
+		return AggCount, fmt.Errorf("unknown aggregation function %s", in)
+	}
+}
+
+// Aggregation implements stateful aggregation expressions, such as
+// count(field) by group > N. State is kept per group in a sliding window that
+// is defined by rule timeframe. Zero timeframe means that window never expires.
+// Events are assumed to arrive roughly in order, as window is evicted from the front.
+type Aggregation struct {
+	Func      AggFunc
+	Field     string
+	GroupBy   string
+	Op        Token
+	Threshold float64
+	Timeframe time.Duration
+
+	mu     sync.Mutex
+	groups map[string]*aggWindow
+	// last time stale groups were cleaned up
+	swept time.Time
+}
+
+// Update adds an event that matched rule detection to the aggregation window
+// Returns true if aggregation condition holds for the group of that event
+func (a *Aggregation) Update(e Event) bool {
+	ts := eventTime(e)
+	var group string
+	if a.GroupBy != "" {
+		val, ok := e.Select(a.GroupBy)
+		if !ok {
+			return false
+		}
+		group = fmt.Sprintf("%v", val)
+	}
+	entry := aggEntry{ts: ts}
+	if a.Field != "" {
+		val, ok := e.Select(a.Field)
+		if !ok {
+			return false
+		}
+		if a.Func == AggCount {
+			entry.val = fmt.Sprintf("%v", val)
+		} else if entry.num, ok = castToFloat(val); !ok {
+			return false
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.groups == nil {
+		a.groups = make(map[string]*aggWindow)
+	}
+	a.sweep(ts)
+	w, ok := a.groups[group]
+	if !ok {
+		w = &aggWindow{distinct: make(map[string]int)}
+		a.groups[group] = w
+	}
+	w.add(entry)
+	if a.Timeframe > 0 {
+		w.evict(ts.Add(-a.Timeframe))
+	}
+	return compareThreshold(a.Op, w.value(a.Func, a.Field != ""), a.Threshold)
+}
+
+// Reset drops all collected aggregation state
+func (a *Aggregation) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.groups = nil
+}
+
+// sweep removes expired groups, so state would not grow indefinitely for high
+// cardinality group by fields; called at most once per timeframe
+func (a *Aggregation) sweep(now time.Time) {
+	if a.Timeframe == 0 || now.Sub(a.swept) < a.Timeframe {
+		return
+	}
+	a.swept = now
+	cutoff := now.Add(-a.Timeframe)
+	for k, w := range a.groups {
+		if w.evict(cutoff); len(w.entries) == 0 {
+			delete(a.groups, k)
+		}
+	}
+}
+
+type aggEntry struct {
+	ts  time.Time
+	val string
+	num float64
+}
+
+// aggWindow holds aggregation state of a single group
+type aggWindow struct {
+	entries []aggEntry
+	// reference counts for count(field) distinct values
+	distinct map[string]int
+	sum      float64
+}
+
+func (w *aggWindow) add(e aggEntry) {
+	w.entries = append(w.entries, e)
+	w.distinct[e.val]++
+	w.sum += e.num
+}
+
+func (w *aggWindow) evict(cutoff time.Time) {
+	var i int
+	for i < len(w.entries) && w.entries[i].ts.Before(cutoff) {
+		e := w.entries[i]
+		if w.distinct[e.val]--; w.distinct[e.val] <= 0 {
+			delete(w.distinct, e.val)
+		}
+		w.sum -= e.num
+		i++
+	}
+	if i > 0 {
+		w.entries = append(w.entries[:0], w.entries[i:]...)
+	}
+}
+
+func (w aggWindow) value(fn AggFunc, field bool) float64 {
+	if len(w.entries) == 0 {
+		return 0
+	}
+	switch fn {
+	case AggCount:
+		if field {
+			return float64(len(w.distinct))
+		}
+		return float64(len(w.entries))
+	case AggSum:
+		return w.sum
+	case AggAvg:
+		return w.sum / float64(len(w.entries))
+	case AggMin, AggMax:
+		val := w.entries[0].num
+		for _, e := range w.entries[1:] {
+			if (fn == AggMin && e.num < val) || (fn == AggMax && e.num > val) {
+				val = e.num
+			}
+		}
+		return val
+	}
+	return 0
+}
+
+func compareThreshold(op Token, val, threshold float64) bool {
+	switch op {
+	case TokOpEq:
+		return val == threshold
+	case TokOpGt:
+		return val > threshold
+	case TokOpGte:
+		return val >= threshold
+	case TokOpLt:
+		return val < threshold
+	case TokOpLte:
+		return val <= threshold
+	}
+	return false
+}
+
+// newAggregation builds aggregation from tokens that follow the pipe separator
+// sequence validation should be done before invoking newAggregation
+func newAggregation(t []Item, timeframe time.Duration) (*Aggregation, error) {
+	if len(t) < 5 || t[0].T != TokKeywordAgg || t[1].T != TokSepLpar {
+		return nil, fmt.Errorf("invalid aggregation expression %+v", t)
+	}
+	fn, err := newAggFunc(t[0].Val)
+	if err != nil {
+		return nil, err
+	}
+	agg := &Aggregation{Func: fn, Timeframe: timeframe}
+	rest := t[2:]
+	if rest[0].T == TokIdentifier {
+		agg.Field = rest[0].Val
+		rest = rest[1:]
+	}
+	if fn != AggCount && agg.Field == "" {
+		return nil, fmt.Errorf("aggregation function %s requires a field", fn)
+	}
+	if len(rest) > 0 && rest[0].T == TokSepRpar {
+		rest = rest[1:]
+	}
+	if len(rest) > 1 && rest[0].T == TokKeywordBy {
+		agg.GroupBy = rest[1].Val
+		rest = rest[2:]
+	}
+	if len(rest) != 2 || rest[1].T != TokLitNum {
+		return nil, fmt.Errorf("aggregation expression missing comparison %+v", t)
+	}
+	agg.Op = rest[0].T
+	if agg.Threshold, err = strconv.ParseFloat(rest[1].Val, 64); err != nil {
+		return nil, err
+	}
+	return agg, nil
+}
+
+// parseTimeframe parses sigma timeframe values, such as 30s, 15m, 12h or 7d
+func parseTimeframe(in string) (time.Duration, error) {
+	if len(in) < 2 {
+		return 0, fmt.Errorf("invalid timeframe %s", in)
+	}
+	n, err := strconv.Atoi(in[:len(in)-1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeframe %s: %s", in, err)
+	}
+	switch in[len(in)-1] {
+	case 's':
+		return time.Duration(n) * time.Second, nil
+	case 'm':
+		return time.Duration(n) * time.Minute, nil
+	case 'h':
+		return time.Duration(n) * time.Hour, nil
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, nil
+	case 'M':
+		return time.Duration(n) * 30 * 24 * time.Hour, nil
+	case 'y':
+		return time.Duration(n) * 365 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid timeframe unit %s", in)
+	}
+}
+
+// eventTime returns event timestamp if event implements Timestamper
+// wall clock time is used otherwise
+func eventTime(e Event) time.Time {
+	if t, ok := e.(Timestamper); ok {
+		if ts, ok := t.Timestamp(); ok {
+			return ts
+		}
+	}
+	return time.Now()
+}
+
+func castToFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,148 @@
+package sigma
+
+import (
+	"testing"
+	"time"
+
+	"github.com/markuskont/datamodels"
+	"gopkg.in/yaml.v2"
+)
+
+type timedEventExample struct {
+	datamodels.Map
+	ts time.Time
+}
+
+// Timestamp implements Timestamper
+func (t timedEventExample) Timestamp() (time.Time, bool) { return t.ts, true }
+
+var aggregation1 = `
+detection:
+  selection:
+    outcome: failure
+  timeframe: 5m
+  condition: selection | count() by src_ip > 2
+`
+
+var aggregation2 = `
+detection:
+  selection:
+    action: connect
+  timeframe: 1m
+  condition: selection | count(dst_port) by src_ip >= 3
+`
+
+var aggregation3 = `
+detection:
+  selection:
+    action: upload
+  timeframe: 1h
+  condition: selection | sum(bytes) by user > 1000
+`
+
+var aggregation4 = `
+detection:
+  count:
+    action: login
+  max:
+    user: root
+  condition: count and not max | count() by user > 1
+`
+
+type aggregationTestCase struct {
+	Rule   string
+	Events []timedEventExample
+	// expected Eval result for each event
+	Match []bool
+}
+
+func newTimedEvent(offset time.Duration, fields datamodels.Map) timedEventExample {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return timedEventExample{Map: fields, ts: base.Add(offset)}
+}
+
+var aggregationTestCases = []aggregationTestCase{
+	{
+		Rule: aggregation1,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"outcome": "failure", "src_ip": "10.0.0.1"}),
+			newTimedEvent(time.Minute, datamodels.Map{"outcome": "failure", "src_ip": "10.0.0.1"}),
+			newTimedEvent(2*time.Minute, datamodels.Map{"outcome": "failure", "src_ip": "10.0.0.2"}),
+			newTimedEvent(2*time.Minute, datamodels.Map{"outcome": "success", "src_ip": "10.0.0.1"}),
+			newTimedEvent(3*time.Minute, datamodels.Map{"outcome": "failure", "src_ip": "10.0.0.1"}),
+			// first event falls out of window
+			newTimedEvent(5*time.Minute+time.Second, datamodels.Map{"outcome": "failure", "src_ip": "10.0.0.1"}),
+			// only events from 3rd minute onward are in window
+			newTimedEvent(8*time.Minute+30*time.Second, datamodels.Map{"outcome": "failure", "src_ip": "10.0.0.1"}),
+		},
+		Match: []bool{false, false, false, false, true, true, false},
+	},
+	{
+		Rule: aggregation2,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"action": "connect", "src_ip": "10.0.0.1", "dst_port": 22}),
+			newTimedEvent(time.Second, datamodels.Map{"action": "connect", "src_ip": "10.0.0.1", "dst_port": 22}),
+			newTimedEvent(2*time.Second, datamodels.Map{"action": "connect", "src_ip": "10.0.0.1", "dst_port": 80}),
+			newTimedEvent(3*time.Second, datamodels.Map{"action": "connect", "src_ip": "10.0.0.1", "dst_port": 443}),
+		},
+		Match: []bool{false, false, false, true},
+	},
+	{
+		Rule: aggregation3,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"action": "upload", "user": "bob", "bytes": 600}),
+			newTimedEvent(time.Second, datamodels.Map{"action": "upload", "user": "alice", "bytes": 600}),
+			newTimedEvent(2*time.Second, datamodels.Map{"action": "upload", "user": "bob", "bytes": "500"}),
+		},
+		Match: []bool{false, false, true},
+	},
+	{
+		// selections may be named after aggregation functions
+		Rule: aggregation4,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"action": "login", "user": "bob"}),
+			newTimedEvent(time.Second, datamodels.Map{"action": "login", "user": "root"}),
+			newTimedEvent(2*time.Second, datamodels.Map{"action": "login", "user": "root"}),
+			newTimedEvent(3*time.Second, datamodels.Map{"action": "login", "user": "bob"}),
+		},
+		Match: []bool{false, false, false, true},
+	},
+}
+
+func TestAggregation(t *testing.T) {
+	for i, c := range aggregationTestCases {
+		var rule Rule
+		if err := yaml.Unmarshal([]byte(c.Rule), &rule); err != nil {
+			t.Fatalf("aggregation case %d failed to unmarshal yaml, %s", i, err)
+		}
+		tree, err := NewTree(RuleHandle{Rule: rule})
+		if err != nil {
+			t.Fatalf("aggregation case %d failed to build tree, %s", i, err)
+		}
+		if tree.Agg == nil {
+			t.Fatalf("aggregation case %d missing aggregation", i)
+		}
+		for j, e := range c.Events {
+			if _, match := tree.Eval(e); match != c.Match[j] {
+				t.Fatalf("aggregation case %d event %d expected %t got %t", i, j, c.Match[j], match)
+			}
+		}
+	}
+}
+
+func TestAggregationInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"selection | sum() > 10",
+		"selection | count() by",
+		"selection | count() by src_ip",
+		"selection | count() > abc",
+	} {
+		rule := RuleHandle{Rule: Rule{Detection: Detection{
+			"condition": expr,
+			"selection": map[interface{}]interface{}{"field": "value"},
+		}}}
+		if _, err := NewTree(rule); err == nil {
+			t.Fatalf("aggregation expression %s should fail", expr)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package sigma
+
+// ahoCorasick is a multi-pattern automaton that finds all patterns in text in a single pass
+type ahoCorasick struct {
+	nodes []acNode
+}
+
+type acNode struct {
+	next map[byte]int32
+	fail int32
+	// out holds ids of patterns that end at this node, including those found via fail links
+	out []int
+}
+
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	a := &ahoCorasick{nodes: []acNode{{next: make(map[byte]int32)}}}
+	for id, p := range patterns {
+		var state int32
+		for i := 0; i < len(p); i++ {
+			next, ok := a.nodes[state].next[p[i]]
+			if !ok {
+				a.nodes = append(a.nodes, acNode{next: make(map[byte]int32)})
+				next = int32(len(a.nodes) - 1)
+				a.nodes[state].next[p[i]] = next
+			}
+			state = next
+		}
+		a.nodes[state].out = append(a.nodes[state].out, id)
+	}
+	// fail links are built breadth first, so that links of shorter prefixes are already known
+	queue := make([]int32, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for c, child := range a.nodes[state].next {
+			fail := a.nodes[state].fail
+			for {
+				if next, ok := a.nodes[fail].next[c]; ok {
+					a.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = a.nodes[fail].fail
+			}
+			a.nodes[child].out = append(a.nodes[child].out, a.nodes[a.nodes[child].fail].out...)
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+// scan calls fn with id of every pattern occurrence in text
+func (a *ahoCorasick) scan(text string, fn func(id int)) {
+	var state int32
+	for i := 0; i < len(text); i++ {
+		for {
+			if next, ok := a.nodes[state].next[text[i]]; ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = a.nodes[state].fail
+		}
+		for _, id := range a.nodes[state].out {
+			fn(id)
+		}
+	}
+}
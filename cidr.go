@@ -0,0 +1,136 @@
+package sigma
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// NetMatcher is an atomic pattern for matching IP addresses
+type NetMatcher interface {
+	// NetMatch implements NetMatcher
+	NetMatch(netip.Addr) bool
+}
+
+// NewNetMatcher creates a matcher from IPv4 and IPv6 prefixes in CIDR notation
+// A plain address is handled as a single host prefix
+func NewNetMatcher(patterns ...string) (NetMatcher, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("no patterns defined for matcher object")
+	}
+	trie := &PrefixTrie{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		var prefix netip.Prefix
+		if strings.Contains(p, "/") {
+			parsed, err := netip.ParsePrefix(p)
+			if err != nil {
+				return nil, err
+			}
+			prefix = parsed
+		} else {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return nil, err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trie.Insert(prefix)
+	}
+	return trie, nil
+}
+
+// PrefixTrie is a binary trie of network prefixes
+// Lookup cost depends on address length rather than number of prefixes
+type PrefixTrie struct {
+	v4, v6 *prefixNode
+	// Prefixes holds inserted prefixes for debugging
+	Prefixes []netip.Prefix
+}
+
+type prefixNode struct {
+	children [2]*prefixNode
+	// terminal marks the end of an inserted prefix, every address below it is a match
+	terminal bool
+}
+
+// Insert adds prefix to trie
+// IPv4-mapped IPv6 prefixes are stored as IPv4
+func (t *PrefixTrie) Insert(p netip.Prefix) {
+	p = p.Masked()
+	addr, bits := p.Addr(), p.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+	t.Prefixes = append(t.Prefixes, netip.PrefixFrom(addr, bits))
+	root := &t.v6
+	if addr.Is4() {
+		root = &t.v4
+	}
+	if *root == nil {
+		*root = &prefixNode{}
+	}
+	node := *root
+	raw := addr.AsSlice()
+	for i := 0; i < bits; i++ {
+		if node.terminal {
+			// shorter prefix already covers this one
+			return
+		}
+		bit := addrBit(raw, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &prefixNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	node.children = [2]*prefixNode{}
+}
+
+// NetMatch implements NetMatcher
+func (t PrefixTrie) NetMatch(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	node := t.v6
+	if addr.Is4() {
+		node = t.v4
+	}
+	raw := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i >= len(raw)*8 {
+			return false
+		}
+		node = node.children[addrBit(raw, i)]
+	}
+	return false
+}
+
+func addrBit(raw []byte, i int) int {
+	return int(raw[i/8]>>(7-uint(i%8))) & 1
+}
+
+// castToAddr converts event values to IP address
+// Second return value is false if value is not an address
+func castToAddr(val interface{}) (netip.Addr, bool) {
+	switch v := val.(type) {
+	case netip.Addr:
+		return v, v.IsValid()
+	case net.IP:
+		return netip.AddrFromSlice(v)
+	case *net.IP:
+		if v == nil {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFromSlice(*v)
+	case string:
+		addr, err := netip.ParseAddr(strings.TrimSpace(v))
+		return addr, err == nil
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,41 @@
+package sigma
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixTrie(t *testing.T) {
+	m, err := NewNetMatcher("10.0.0.0/8", "10.1.0.0/16", "192.168.1.0/24", "2001:db8::/32", "::ffff:100.64.0.0/106")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		Val   interface{}
+		Match bool
+	}{
+		{Val: "10.200.1.1", Match: true},
+		{Val: "11.0.0.1", Match: false},
+		{Val: "192.168.1.255", Match: true},
+		{Val: "192.168.2.1", Match: false},
+		{Val: "2001:db8:1::1", Match: true},
+		{Val: "2001:db9::1", Match: false},
+		{Val: "100.64.0.1", Match: true},
+		{Val: net.ParseIP("10.0.0.1"), Match: true},
+		{Val: netip.MustParseAddr("::ffff:10.0.0.1"), Match: true},
+		{Val: netip.Addr{}, Match: false},
+	}
+	for i, c := range cases {
+		addr, ok := castToAddr(c.Val)
+		if !ok && c.Match {
+			t.Fatalf("case %d: %v should be an address", i, c.Val)
+		}
+		if match := m.NetMatch(addr); match != c.Match {
+			t.Fatalf("case %d: %v expected %t got %t", i, c.Val, c.Match, match)
+		}
+	}
+	if _, err := NewNetMatcher("10.0.0.0/33"); err == nil {
+		t.Fatal("invalid prefix should fail")
+	}
+}
@@ -0,0 +1,378 @@
+package sigma
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CorrelationType is the kind of sigma correlation rule
+type CorrelationType int
+
+const (
+	CorrelationEventCount CorrelationType = iota
+	CorrelationValueCount
+	CorrelationTemporal
+	CorrelationTemporalOrdered
+)
+
+func (c CorrelationType) String() string {
+	switch c {
+	case CorrelationEventCount:
+		return "event_count"
+	case CorrelationValueCount:
+		return "value_count"
+	case CorrelationTemporal:
+		return "temporal"
+	case CorrelationTemporalOrdered:
+		return "temporal_ordered"
+	default:
+		return "Unk"
+	}
+}
+
+func newCorrelationType(in string) (CorrelationType, error) {
+	switch in {
+	case "event_count":
+		return CorrelationEventCount, nil
+	case "value_count":
+		return CorrelationValueCount, nil
+	case "temporal":
+		return CorrelationTemporal, nil
+	case "temporal_ordered":
+		return CorrelationTemporalOrdered, nil
+	default:
+		return CorrelationEventCount, fmt.Errorf("unknown correlation type %s", in)
+	}
+}
+
+// CorrelationBound is a single comparison from correlation condition, such as gte: 100
+type CorrelationBound struct {
+	Op    Token
+	Value float64
+}
+
+// Correlator is the compiled counterpart of a sigma correlation rule
+// Like Tree, it holds the originating rule handle
+// Unlike Tree, it does not look at raw events but consumes results of other rules
+type Correlator struct {
+	Rule *RuleHandle
+
+	Type     CorrelationType
+	GroupBy  []string
+	Timespan time.Duration
+	// Field is the value_count field
+	Field  string
+	Bounds []CorrelationBound
+
+	// refs maps resolved rule ID to position in referenced rule list
+	refs map[string]int
+	// aliases holds group-by field per referenced rule, indexed the same way as GroupBy
+	aliases []map[string]string
+
+	mu     sync.Mutex
+	groups map[string]*correlationGroup
+	swept  time.Time
+}
+
+// NewCorrelator parses correlation rule handle into a Correlator
+// References to other rules must be resolved before the correlator can be used, see Ruleset
+func NewCorrelator(r RuleHandle) (*Correlator, error) {
+	if r.Correlation == nil {
+		return nil, fmt.Errorf("rule %s is not a correlation rule", r.ID)
+	}
+	spec := r.Correlation
+	t, err := newCorrelationType(spec.Type)
+	if err != nil {
+		return nil, err
+	}
+	if len(spec.Rules) == 0 {
+		return nil, fmt.Errorf("correlation rule %s does not reference any rules", r.ID)
+	}
+	if spec.Timespan == "" {
+		return nil, fmt.Errorf("correlation rule %s is missing timespan", r.ID)
+	}
+	timespan, err := parseTimeframe(spec.Timespan)
+	if err != nil {
+		return nil, err
+	}
+	// group-by and value_count fields are read from events, so pipelines map them like rule fields
+	transforms := newRuleTransformations(r.Pipelines, r.Logsource)
+	c := &Correlator{
+		Rule:     &r,
+		Type:     t,
+		Timespan: timespan,
+	}
+	for _, field := range spec.GroupBy {
+		c.GroupBy = append(c.GroupBy, transforms.fieldName(field))
+		var aliases map[string]string
+		if alias, ok := spec.Aliases[field]; ok {
+			aliases = make(map[string]string, len(alias))
+			for ref, f := range alias {
+				aliases[ref] = transforms.fieldName(f)
+			}
+		}
+		c.aliases = append(c.aliases, aliases)
+	}
+	for key, val := range spec.Condition {
+		if key == "field" {
+			c.Field = transforms.fieldName(fmt.Sprintf("%v", val))
+			continue
+		}
+		op, err := newCorrelationOp(key)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseFloat(fmt.Sprintf("%v", val), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid correlation condition %s: %s", key, err)
+		}
+		c.Bounds = append(c.Bounds, CorrelationBound{Op: op, Value: n})
+	}
+	switch t {
+	case CorrelationEventCount, CorrelationValueCount:
+		if len(c.Bounds) == 0 {
+			return nil, fmt.Errorf("correlation rule %s is missing condition", r.ID)
+		}
+		if t == CorrelationValueCount && c.Field == "" {
+			return nil, fmt.Errorf("value_count correlation rule %s is missing condition field", r.ID)
+		}
+	}
+	return c, nil
+}
+
+func newCorrelationOp(in string) (Token, error) {
+	switch in {
+	case "eq":
+		return TokOpEq, nil
+	case "gt":
+		return TokOpGt, nil
+	case "gte":
+		return TokOpGte, nil
+	case "lt":
+		return TokOpLt, nil
+	case "lte":
+		return TokOpLte, nil
+	default:
+		return TokErr, fmt.Errorf("unknown correlation condition operator %s", in)
+	}
+}
+
+// References returns rule ids or names as listed in correlation rule
+func (c *Correlator) References() []string {
+	return c.Rule.Correlation.Rules
+}
+
+// resolve maps referenced rule ids and names to rule ids
+func (c *Correlator) resolve(lookup map[string]string) error {
+	c.refs = make(map[string]int, len(c.References()))
+	for i, ref := range c.References() {
+		id, ok := lookup[ref]
+		if !ok {
+			return ErrUnresolvedCorrelation{Rule: c.Rule.ID, Ref: ref}
+		}
+		c.refs[id] = i
+	}
+	return nil
+}
+
+// Eval updates correlation state with results of other rules for that event
+// Returns a result for correlation rule if correlation condition holds
+func (c *Correlator) Eval(e Event, results Results) (*Result, bool) {
+	var match bool
+	ts := eventTime(e)
+	for _, res := range results {
+		idx, ok := c.refs[res.ID]
+		if !ok {
+			continue
+		}
+		if c.update(e, ts, idx) {
+			match = true
+		}
+	}
+	if !match {
+		return nil, false
+	}
+	return &Result{
+		ID:          c.Rule.ID,
+		Title:       c.Rule.Title,
+		Tags:        c.Rule.Tags,
+		Description: c.Rule.Description,
+	}, true
+}
+
+// Reset drops all collected correlation state
+func (c *Correlator) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups = nil
+}
+
+func (c *Correlator) update(e Event, ts time.Time, idx int) bool {
+	ref := c.References()[idx]
+	key := make([]string, len(c.GroupBy))
+	for i, field := range c.GroupBy {
+		if i < len(c.aliases) {
+			if f, ok := c.aliases[i][ref]; ok {
+				field = f
+			}
+		}
+		val, ok := e.Select(field)
+		if !ok {
+			// events without group-by field do not belong to any group
+			return false
+		}
+		key[i] = fmt.Sprintf("%v", val)
+	}
+	entry := aggEntry{ts: ts}
+	if c.Type == CorrelationValueCount {
+		val, ok := e.Select(c.Field)
+		if !ok {
+			return false
+		}
+		entry.val = fmt.Sprintf("%v", val)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.groups == nil {
+		c.groups = make(map[string]*correlationGroup)
+	}
+	c.sweep(ts)
+	group := strings.Join(key, "\x00")
+	g, ok := c.groups[group]
+	if !ok {
+		g = &correlationGroup{
+			window: aggWindow{distinct: make(map[string]int)},
+			seen:   make([]time.Time, len(c.References())),
+		}
+		c.groups[group] = g
+	}
+	cutoff := ts.Add(-c.Timespan)
+
+	switch c.Type {
+	case CorrelationEventCount, CorrelationValueCount:
+		g.window.add(entry)
+		g.window.evict(cutoff)
+		val := g.window.value(AggCount, c.Type == CorrelationValueCount)
+		for _, b := range c.Bounds {
+			if !compareThreshold(b.Op, val, b.Value) {
+				return false
+			}
+		}
+		return true
+	case CorrelationTemporal:
+		g.seen[idx] = ts
+		for _, seen := range g.seen {
+			if seen.IsZero() || seen.Before(cutoff) {
+				return false
+			}
+		}
+		g.reset()
+		return true
+	case CorrelationTemporalOrdered:
+		// seen holds start time of the most recent sequence that has reached that step
+		// latest start leaves most room for the remaining steps
+		if idx == 0 {
+			g.seen[0] = ts
+		} else if prev := g.seen[idx-1]; !prev.IsZero() && !prev.Before(cutoff) {
+			g.seen[idx] = prev
+		}
+		last := g.seen[len(g.seen)-1]
+		if idx == len(g.seen)-1 && !last.IsZero() && !last.Before(cutoff) {
+			g.reset()
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+func (c *Correlator) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.Timespan {
+		return
+	}
+	c.swept = now
+	cutoff := now.Add(-c.Timespan)
+	for k, g := range c.groups {
+		if g.expired(cutoff) {
+			delete(c.groups, k)
+		}
+	}
+}
+
+// correlationGroup holds correlation state for a single group-by value combination
+type correlationGroup struct {
+	// window is used by event_count and value_count
+	window aggWindow
+	// seen is used by temporal correlation types, one timestamp per referenced rule
+	seen []time.Time
+}
+
+// reset clears temporal state after correlation fires, so that every sequence is reported once
+func (g *correlationGroup) reset() {
+	for i := range g.seen {
+		g.seen[i] = time.Time{}
+	}
+}
+
+func (g *correlationGroup) expired(cutoff time.Time) bool {
+	if g.window.evict(cutoff); len(g.window.entries) > 0 {
+		return false
+	}
+	for _, seen := range g.seen {
+		if !seen.IsZero() && !seen.Before(cutoff) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortCorrelators orders correlators so that correlations referencing other
+// correlations are evaluated after them
+// Correlators with circular references are dropped and counted as failed
+func sortCorrelators(in []*Correlator) ([]*Correlator, int) {
+	byID := make(map[string]*Correlator, len(in))
+	for _, c := range in {
+		byID[c.Rule.ID] = c
+	}
+	const (
+		unvisited = iota
+		visiting
+		done
+		broken
+	)
+	state := make(map[*Correlator]int, len(in))
+	out := make([]*Correlator, 0, len(in))
+	var visit func(c *Correlator) bool
+	visit = func(c *Correlator) bool {
+		switch state[c] {
+		case visiting, broken:
+			return false
+		case done:
+			return true
+		}
+		state[c] = visiting
+		ids := make([]string, 0, len(c.refs))
+		for id := range c.refs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if dep, ok := byID[id]; ok && !visit(dep) {
+				state[c] = broken
+				return false
+			}
+		}
+		state[c] = done
+		out = append(out, c)
+		return true
+	}
+	for _, c := range in {
+		visit(c)
+	}
+	return out, len(in) - len(out)
+}
@@ -0,0 +1,177 @@
+package sigma
+
+import (
+	"testing"
+	"time"
+
+	"github.com/markuskont/datamodels"
+	"gopkg.in/yaml.v2"
+)
+
+var correlationBaseRules = []string{`
+title: Failed logon
+id: 0e95725d-7320-415d-80f7-004da920fc11
+name: failed_logon
+detection:
+  selection:
+    EventID: 4625
+  condition: selection
+`, `
+title: Successful logon
+id: 4d0a2c83-c62c-4ed4-b475-c7e23a9269b8
+name: successful_logon
+detection:
+  selection:
+    EventID: 4624
+  condition: selection
+`}
+
+var correlation1 = `
+title: Many failed logons
+id: 0e95725d-7320-415d-80f7-004da920fc12
+correlation:
+  type: event_count
+  rules:
+    - failed_logon
+  group-by:
+    - User
+  timespan: 5m
+  condition:
+    gte: 3
+`
+
+var correlation2 = `
+title: Password spraying
+id: 0e95725d-7320-415d-80f7-004da920fc13
+correlation:
+  type: value_count
+  rules:
+    - 0e95725d-7320-415d-80f7-004da920fc11
+  group-by:
+    - Source
+  timespan: 5m
+  condition:
+    field: User
+    gt: 2
+  generate: true
+`
+
+var correlation3 = `
+title: Brute force success
+id: 0e95725d-7320-415d-80f7-004da920fc14
+correlation:
+  type: temporal_ordered
+  rules:
+    - failed_logon
+    - successful_logon
+  group-by:
+    - User
+  timespan: 1m
+`
+
+type correlationTestCase struct {
+	Rule   string
+	Events []timedEventExample
+	// expected correlation result for each event
+	Match []bool
+	// expected ruleset result count for each event
+	Results []int
+}
+
+var correlationTestCases = []correlationTestCase{
+	{
+		Rule: correlation1,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"EventID": 4625, "User": "bob"}),
+			newTimedEvent(time.Second, datamodels.Map{"EventID": 4625, "User": "alice"}),
+			newTimedEvent(2*time.Second, datamodels.Map{"EventID": 4625, "User": "bob"}),
+			newTimedEvent(3*time.Second, datamodels.Map{"EventID": 4624, "User": "bob"}),
+			newTimedEvent(4*time.Second, datamodels.Map{"EventID": 4625, "User": "bob"}),
+		},
+		Match: []bool{false, false, false, false, true},
+		// failed logon results are suppressed as generate is not set
+		Results: []int{0, 0, 0, 1, 1},
+	},
+	{
+		Rule: correlation2,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"EventID": 4625, "User": "bob", "Source": "10.0.0.1"}),
+			newTimedEvent(time.Second, datamodels.Map{"EventID": 4625, "User": "bob", "Source": "10.0.0.1"}),
+			newTimedEvent(2*time.Second, datamodels.Map{"EventID": 4625, "User": "alice", "Source": "10.0.0.1"}),
+			newTimedEvent(3*time.Second, datamodels.Map{"EventID": 4625, "User": "carol", "Source": "10.0.0.2"}),
+			newTimedEvent(4*time.Second, datamodels.Map{"EventID": 4625, "User": "carol", "Source": "10.0.0.1"}),
+		},
+		Match:   []bool{false, false, false, false, true},
+		Results: []int{1, 1, 1, 1, 2},
+	},
+	{
+		Rule: correlation3,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"EventID": 4624, "User": "bob"}),
+			newTimedEvent(time.Second, datamodels.Map{"EventID": 4625, "User": "bob"}),
+			newTimedEvent(2*time.Second, datamodels.Map{"EventID": 4624, "User": "alice"}),
+			newTimedEvent(3*time.Second, datamodels.Map{"EventID": 4624, "User": "bob"}),
+			// sequence has already been reported
+			newTimedEvent(4*time.Second, datamodels.Map{"EventID": 4624, "User": "bob"}),
+			newTimedEvent(5*time.Minute, datamodels.Map{"EventID": 4624, "User": "bob"}),
+		},
+		Match:   []bool{false, false, false, true, false, false},
+		Results: []int{0, 0, 0, 1, 0, 0},
+	},
+	{
+		// events without group-by field are not counted
+		Rule: correlation1,
+		Events: []timedEventExample{
+			newTimedEvent(0, datamodels.Map{"EventID": 4625}),
+			newTimedEvent(time.Second, datamodels.Map{"EventID": 4625, "Host": "a"}),
+			newTimedEvent(2*time.Second, datamodels.Map{"EventID": 4625, "Host": "b"}),
+		},
+		Match:   []bool{false, false, false},
+		Results: []int{0, 0, 0},
+	},
+}
+
+func TestCorrelation(t *testing.T) {
+	for i, c := range correlationTestCases {
+		handles := make([]RuleHandle, 0)
+		for _, raw := range append(correlationBaseRules, c.Rule) {
+			var rule Rule
+			if err := yaml.Unmarshal([]byte(raw), &rule); err != nil {
+				t.Fatalf("correlation case %d failed to unmarshal yaml, %s", i, err)
+			}
+			handles = append(handles, RuleHandle{Rule: rule})
+		}
+		ruleset := RulesetFromRuleList(handles)
+		if ruleset.Failed > 0 || len(ruleset.Correlations) != 1 {
+			t.Fatalf("correlation case %d failed to build ruleset, %d failed", i, ruleset.Failed)
+		}
+		id := ruleset.Correlations[0].Rule.ID
+		for j, e := range c.Events {
+			results, _ := ruleset.EvalAll(e)
+			var match bool
+			for _, res := range results {
+				if res.ID == id {
+					match = true
+				}
+			}
+			if match != c.Match[j] {
+				t.Fatalf("correlation case %d event %d expected %t got %t", i, j, c.Match[j], match)
+			}
+			if len(results) != c.Results[j] {
+				t.Fatalf("correlation case %d event %d expected %d results got %d",
+					i, j, c.Results[j], len(results))
+			}
+		}
+	}
+}
+
+func TestCorrelationUnresolved(t *testing.T) {
+	var rule Rule
+	if err := yaml.Unmarshal([]byte(correlation3), &rule); err != nil {
+		t.Fatal(err)
+	}
+	ruleset := RulesetFromRuleList([]RuleHandle{{Rule: rule}})
+	if ruleset.Failed != 1 || len(ruleset.Correlations) != 0 {
+		t.Fatalf("correlation with unknown references should fail, got %d failed", ruleset.Failed)
+	}
+}
@@ -0,0 +1,263 @@
+package sigma
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// NodeInfo is a serializable description of compiled tree node
+// Used for debugging what rule was actually compiled to, for example escaping of glob patterns
+type NodeInfo struct {
+	// Kind is node type name, e.g. NodeAnd, Selection or GlobPattern
+	Kind string `json:"kind"`
+	// Key is the event field of selection item
+	Key string `json:"key,omitempty"`
+	// Modifiers of selection item as they were written in rule
+	Modifiers []string `json:"modifiers,omitempty"`
+	// Op is comparison operator of numeric pattern
+	Op string `json:"op,omitempty"`
+	// Pattern is the final string that is matched, after escaping and whitespace handling
+	Pattern string `json:"pattern,omitempty"`
+	// Values holds list values, such as booleans, referenced fields or network prefixes
+	Values []string `json:"values,omitempty"`
+	// Flags holds boolean pattern options, such as lowercase or nocollapsews
+	Flags    []string   `json:"flags,omitempty"`
+	Children []NodeInfo `json:"children,omitempty"`
+}
+
+// Describe builds a description of node and all its children
+func Describe(n Node) NodeInfo {
+	d := &describer{}
+	walk(n, d)
+	return d.root
+}
+
+// describer is a Visitor that collects NodeInfo for every visited node
+type describer struct {
+	stack []NodeInfo
+	root  NodeInfo
+}
+
+// Enter implements Visitor
+func (d *describer) Enter(n Node) bool {
+	d.stack = append(d.stack, describeNode(n))
+	return true
+}
+
+// Leave implements Visitor
+func (d *describer) Leave(n Node) {
+	info := d.stack[len(d.stack)-1]
+	d.stack = d.stack[:len(d.stack)-1]
+	if len(d.stack) == 0 {
+		d.root = info
+		return
+	}
+	parent := &d.stack[len(d.stack)-1]
+	parent.Children = append(parent.Children, info)
+}
+
+func describeNode(n Node) NodeInfo {
+	info := NodeInfo{Kind: nodeKind(n)}
+	switch v := indirectNode(n).(type) {
+	case SelectionExistsItem:
+		info.Key, info.Modifiers = v.Key, v.Modifiers
+		switch {
+		case v.Null:
+			info.Flags = []string{"null"}
+		case v.Exists:
+			info.Flags = []string{"exists"}
+		default:
+			info.Flags = []string{"missing"}
+		}
+	case SelectionNumItem:
+		info.Key, info.Modifiers = v.Key, v.Modifiers
+	case SelectionStringItem:
+		info.Key, info.Modifiers = v.Key, v.Modifiers
+	case SelectionNetItem:
+		info.Key, info.Modifiers = v.Key, v.Modifiers
+	case SelectionBoolItem:
+		info.Key, info.Modifiers = v.Key, v.Modifiers
+		for _, b := range v.Values {
+			info.Values = append(info.Values, strconv.FormatBool(b))
+		}
+	case SelectionFieldRefItem:
+		info.Key, info.Modifiers, info.Values = v.Key, v.Modifiers, v.Refs
+		info.Flags = flags(map[string]bool{"all": v.All, "lowercase": v.Lowercase})
+	case ContentPattern:
+		info.Pattern = v.Token
+		info.Flags = flags(map[string]bool{"lowercase": v.Lowercase, "nocollapsews": v.NoCollapseWS})
+	case PrefixPattern:
+		info.Pattern = v.Token
+		info.Flags = flags(map[string]bool{"lowercase": v.Lowercase, "nocollapsews": v.NoCollapseWS})
+	case SuffixPattern:
+		info.Pattern = v.Token
+		info.Flags = flags(map[string]bool{"lowercase": v.Lowercase, "nocollapsews": v.NoCollapseWS})
+	case SimplePattern:
+		info.Pattern = v.Token
+		info.Flags = flags(map[string]bool{"nocollapsews": v.NoCollapseWS})
+	case GlobPattern:
+		info.Pattern = v.Pattern
+		info.Flags = flags(map[string]bool{"nocollapsews": v.NoCollapseWS})
+	case RegexPattern:
+		if v.Re != nil {
+			info.Pattern = v.Re.String()
+		}
+	case BytePattern:
+		info.Pattern = v.String()
+	case NumPattern:
+		info.Op, info.Pattern = numOpLiteral(v.Op), v.Val.String()
+	case PrefixTrie:
+		for _, p := range v.Prefixes {
+			info.Values = append(info.Values, p.String())
+		}
+	}
+	return info
+}
+
+// indirectNode dereferences pointer nodes, so they can be type switched by value
+func indirectNode(n Node) interface{} {
+	if v := reflect.ValueOf(n); v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem().Interface()
+	}
+	return n
+}
+
+// nodeKind returns type name of node without package and pointer prefix
+func nodeKind(n Node) string {
+	t := reflect.TypeOf(n)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// flags returns names of options that are set, in sorted order
+func flags(opts map[string]bool) []string {
+	var out []string
+	for _, name := range []string{"all", "lowercase", "nocollapsews"} {
+		if opts[name] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func numOpLiteral(op Token) string {
+	switch op {
+	case TokOpGt, TokOpGte, TokOpLt, TokOpLte:
+		return op.Literal()
+	default:
+		return TokOpEq.Literal()
+	}
+}
+
+// String renders byte pattern as glob, bytes that are not printable ASCII are hex escaped
+func (b BytePattern) String() string {
+	var sb strings.Builder
+	for _, t := range b.Tokens {
+		switch {
+		case t == byteWildcard:
+			sb.WriteByte('*')
+		case t == byteSingle:
+			sb.WriteByte('?')
+		case t == '*' || t == '?' || t == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(byte(t))
+		case t < 0x20 || t > 0x7e:
+			fmt.Fprintf(&sb, "\\x%02x", t)
+		default:
+			sb.WriteByte(byte(t))
+		}
+	}
+	return sb.String()
+}
+
+// String renders node and its children as indented text, one node per line
+func (n NodeInfo) String() string {
+	var sb strings.Builder
+	n.render(&sb, 0)
+	return sb.String()
+}
+
+func (n NodeInfo) render(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(n.Kind)
+	if n.Key != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(strings.Join(append([]string{n.Key}, n.Modifiers...), "|"))
+	}
+	if n.Op != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(n.Op)
+	}
+	if n.Pattern != "" {
+		fmt.Fprintf(sb, " '%s'", n.Pattern)
+	}
+	if len(n.Values) > 0 {
+		fmt.Fprintf(sb, " [%s]", strings.Join(n.Values, ", "))
+	}
+	for _, f := range n.Flags {
+		sb.WriteString(" +")
+		sb.WriteString(f)
+	}
+	sb.WriteByte('\n')
+	for _, c := range n.Children {
+		c.render(sb, depth+1)
+	}
+}
+
+// treeInfo is serializable description of Tree
+type treeInfo struct {
+	ID          string   `json:"id,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Root        NodeInfo `json:"root"`
+	Aggregation string   `json:"aggregation,omitempty"`
+}
+
+func (t Tree) info() treeInfo {
+	info := treeInfo{Root: Describe(t.Root)}
+	if t.Rule != nil {
+		info.ID, info.Title = t.Rule.ID, t.Rule.Title
+	}
+	if t.Agg != nil {
+		info.Aggregation = t.Agg.String()
+	}
+	return info
+}
+
+// MarshalJSON implements json.Marshaler
+// Output describes compiled tree, rather than raw rule, and is stable for the same rule
+func (t Tree) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.info())
+}
+
+// String renders compiled tree as indented text
+func (t Tree) String() string {
+	info := t.info()
+	var sb strings.Builder
+	if info.ID != "" || info.Title != "" {
+		fmt.Fprintf(&sb, "Rule %s %s\n", info.ID, info.Title)
+	}
+	info.Root.render(&sb, 0)
+	if info.Aggregation != "" {
+		fmt.Fprintf(&sb, "Aggregation %s\n", info.Aggregation)
+	}
+	return sb.String()
+}
+
+// String renders aggregation expression as written in condition, with timeframe if one is set
+func (a *Aggregation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s(%s)", a.Func, a.Field)
+	if a.GroupBy != "" {
+		fmt.Fprintf(&sb, " by %s", a.GroupBy)
+	}
+	fmt.Fprintf(&sb, " %s %s", a.Op.Literal(), strconv.FormatFloat(a.Threshold, 'f', -1, 64))
+	if a.Timeframe > 0 {
+		fmt.Fprintf(&sb, " timeframe %s", a.Timeframe)
+	}
+	return sb.String()
+}
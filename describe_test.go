@@ -0,0 +1,106 @@
+package sigma
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+var describeRule = `
+id: 2e2bd0ae-2d8b-4a5e-9b8f-3c7d8ddf2d0b
+title: describe
+detection:
+  condition: selection and not filter | count() by host > 5
+  timeframe: 5m
+  selection:
+    Image|endswith: '\cmd.exe'
+    CommandLine:
+      - '*\\*'
+      - '*[x]  y*'
+    EventID|gte: 4
+  filter:
+    Elevated: true
+    Parent|exists: false
+    Source|cidr: 10.0.0.0/8
+    User|re|i: '^admin'
+`
+
+func TestTreeDescribe(t *testing.T) {
+	var rule Rule
+	if err := yaml.Unmarshal([]byte(describeRule), &rule); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTree(RuleHandle{Rule: rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Rule 2e2bd0ae-2d8b-4a5e-9b8f-3c7d8ddf2d0b describe
+NodeAnd
+  Selection
+    SelectionNumItem EventID|gte
+      NumPattern >= '4'
+    SelectionStringItem CommandLine
+      LowercasePattern
+        StringMatchers
+          GlobPattern '*\\*'
+          GlobPattern '*\[x\] y*'
+    SelectionStringItem Image|endswith
+      LowercasePattern
+        SuffixPattern '\cmd.exe'
+  NodeNot
+    Selection
+      SelectionExistsItem Parent|exists +missing
+      SelectionStringItem User|re|i
+        RegexPattern '(?i)^admin'
+      SelectionBoolItem Elevated [true]
+      SelectionNetItem Source|cidr
+        PrefixTrie [10.0.0.0/8]
+Aggregation count() by host > 5 timeframe 5m0s
+`
+	if got := tree.String(); got != expected {
+		t.Fatalf("tree rendered as\n%s\nexpected\n%s", got, expected)
+	}
+
+	// JSON form must be stable, as item order does not depend on map iteration
+	first, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		again, err := NewTree(RuleHandle{Rule: rule})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := json.Marshal(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != string(first) {
+			t.Fatalf("tree json is not stable\n%s\n%s", first, out)
+		}
+	}
+	var decoded struct {
+		ID   string   `json:"id"`
+		Root NodeInfo `json:"root"`
+	}
+	if err := json.Unmarshal(first, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	glob := decoded.Root.Children[0].Children[1].Children[0].Children[0].Children[1]
+	if decoded.ID != rule.ID || glob.Kind != "GlobPattern" || glob.Pattern != `*\[x\] y*` {
+		t.Fatalf("unexpected json description %s", first)
+	}
+}
+
+func TestBytePatternString(t *testing.T) {
+	utf16, _ := newValueTransform("utf16le")
+	m, err := newTransformedStringMatcher(TextPatternContains, false, false, false,
+		[]valueTransform{utf16}, `a\*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.(BytePattern).String(); got != `*a\x00\*\x00*` {
+		t.Fatalf("byte pattern rendered as %s", got)
+	}
+}
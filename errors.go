@@ -4,19 +4,33 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 // ErrInvalidRegex contextualizes broken regular expressions presented by the user
+// Rule and Field are filled in when known, PCRE-only constructs like lookarounds end up here
 type ErrInvalidRegex struct {
+	Rule    string
+	Field   string
 	Pattern string
 	Err     error
 }
 
 // Error implements error
 func (e ErrInvalidRegex) Error() string {
-	return fmt.Sprintf("/%s/ %s", e.Pattern, e.Err)
+	var prefix string
+	if e.Rule != "" {
+		prefix += fmt.Sprintf("rule %s ", e.Rule)
+	}
+	if e.Field != "" {
+		prefix += fmt.Sprintf("field %s ", e.Field)
+	}
+	return fmt.Sprintf("%s/%s/ %s", prefix, e.Pattern, e.Err)
 }
 
+// Unwrap returns the underlying regexp error
+func (e ErrInvalidRegex) Unwrap() error { return e.Err }
+
 // ErrMissingDetection indicates missing detection field
 type ErrMissingDetection struct{}
 
@@ -111,6 +125,31 @@ func (e ErrInvalidTokenSeq) Error() string {
 		len(e.Collected), e.Prev.T, e.Next.T, e.Prev.Val, e.Next.Val)
 }
 
+// ErrParseCondition indicates condition syntax error at a specific offset
+// Expected lists tokens that would have been valid in place of Got
+type ErrParseCondition struct {
+	Condition string
+	Pos       int
+	Expected  []Token
+	Got       Item
+}
+
+func (e ErrParseCondition) Error() string {
+	got := fmt.Sprintf("%s %q", e.Got.T, e.Got.Val)
+	if e.Got.T == TokLitEof {
+		got = "end of condition"
+	}
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("condition %q: unexpected %s at offset %d", e.Condition, got, e.Pos)
+	}
+	expected := make([]string, len(e.Expected))
+	for i, t := range e.Expected {
+		expected[i] = t.String()
+	}
+	return fmt.Sprintf("condition %q: unexpected %s at offset %d, expected %s",
+		e.Condition, got, e.Pos, strings.Join(expected, " or "))
+}
+
 // ErrIncompleteTokenSeq is invoked when lex channel drain does not end with EOF
 // thus indicating incomplete lexing sequence
 type ErrIncompleteTokenSeq struct {
@@ -193,6 +232,28 @@ func (e ErrUnsupportedExpression) Error() string {
 		}(), e.T, e.Msg, e.Expr)
 }
 
+// ErrUnresolvedCorrelation indicates that correlation rule references a rule
+// id or name that is not present in ruleset
+type ErrUnresolvedCorrelation struct {
+	Rule string
+	Ref  string
+}
+
+func (e ErrUnresolvedCorrelation) Error() string {
+	return fmt.Sprintf("correlation rule %s references unknown rule %s", e.Rule, e.Ref)
+}
+
+// ErrUnresolvedPlaceholder indicates a placeholder in value with expand modifier
+// that placeholder provider has no values for
+type ErrUnresolvedPlaceholder struct {
+	Rule string
+	Name string
+}
+
+func (e ErrUnresolvedPlaceholder) Error() string {
+	return fmt.Sprintf("rule %s has unresolved placeholder %%%s%%", e.Rule, e.Name)
+}
+
 // ErrUnableToReflect indicates that kind reflection could not be done, as
 // typeOf returned a nil value
 // likely a missing pattern
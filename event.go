@@ -0,0 +1,160 @@
+package sigma
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// MapEvent implements Event for nested maps, such as decoded JSON objects
+// Fields are selected by dotted path, e.g. process.parent.name
+// Literal dot in a key is escaped with backslash and array elements are selected by index,
+// e.g. dns.answers[0].data or labels.app\.kubernetes\.io/name
+type MapEvent struct {
+	Data map[string]interface{}
+	// KeywordFields lists paths of fields that keyword rules are matched against
+	// Keywords are not supported if empty
+	KeywordFields []string
+}
+
+// NewMapEvent wraps map into an Event
+func NewMapEvent(data map[string]interface{}, keywordFields ...string) MapEvent {
+	return MapEvent{Data: data, KeywordFields: keywordFields}
+}
+
+// Keywords implements Keyworder
+// Array fields contribute every element
+func (m MapEvent) Keywords() ([]string, bool) {
+	if len(m.KeywordFields) == 0 {
+		return nil, false
+	}
+	tx := make([]string, 0, len(m.KeywordFields))
+	for _, field := range m.KeywordFields {
+		val, ok := m.Select(field)
+		if !ok {
+			continue
+		}
+		if elems, ok := arrayValue(val); ok {
+			for _, elem := range elems {
+				if str, ok := stringValue(elem); ok {
+					tx = append(tx, str)
+				}
+			}
+			continue
+		}
+		if str, ok := stringValue(val); ok {
+			tx = append(tx, str)
+		}
+	}
+	return tx, len(tx) > 0
+}
+
+// Select implements Selector
+// Key that is present as is takes precedence over dotted path lookup, so flattened maps also work
+func (m MapEvent) Select(key string) (interface{}, bool) {
+	if val, ok := m.Data[key]; ok {
+		return val, true
+	}
+	if !strings.ContainsAny(key, `.[\`) {
+		return nil, false
+	}
+	var current interface{} = m.Data
+	for _, seg := range splitFieldPath(key) {
+		next, ok := selectSegment(current, seg)
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+// pathSegment is either a map key or, when index is set, an array index
+type pathSegment struct {
+	key   string
+	idx   int
+	index bool
+}
+
+// splitFieldPath parses a dotted path into segments
+// Backslash escapes the following character, [n] selects n-th element of an array
+func splitFieldPath(path string) []pathSegment {
+	segments := make([]pathSegment, 0, strings.Count(path, ".")+1)
+	var b strings.Builder
+	// pending is set when key part of segment needs to be flushed, even if empty
+	pending := true
+	flush := func() {
+		if pending {
+			segments = append(segments, pathSegment{key: b.String()})
+		}
+		b.Reset()
+		pending = false
+	}
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+			}
+			b.WriteByte(path[i])
+			pending = true
+		case '.':
+			flush()
+			pending = true
+		case '[':
+			if end := strings.IndexByte(path[i:], ']'); end > 1 {
+				if idx, err := strconv.Atoi(path[i+1 : i+end]); err == nil {
+					flush()
+					segments = append(segments, pathSegment{idx: idx, index: true})
+					i += end
+					continue
+				}
+			}
+			// not an index, handle as part of key
+			b.WriteByte(c)
+			pending = true
+		default:
+			b.WriteByte(c)
+			pending = true
+		}
+	}
+	flush()
+	return segments
+}
+
+func selectSegment(val interface{}, seg pathSegment) (interface{}, bool) {
+	if seg.index {
+		elems, ok := arrayValue(val)
+		if !ok || seg.idx < 0 || seg.idx >= len(elems) {
+			return nil, false
+		}
+		return elems[seg.idx], true
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		next, ok := v[seg.key]
+		return next, ok
+	case map[interface{}]interface{}:
+		next, ok := v[seg.key]
+		return next, ok
+	case MapEvent:
+		next, ok := v.Data[seg.key]
+		return next, ok
+	case nil:
+		return nil, false
+	}
+	// named map types, such as datamodels.Map
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		next := rv.MapIndex(reflect.ValueOf(seg.key).Convert(rv.Type().Key()))
+		if !next.IsValid() {
+			return nil, false
+		}
+		return next.Interface(), true
+	}
+	// numeric segment can also index an array, e.g. args.0
+	if idx, err := strconv.Atoi(seg.key); err == nil {
+		return selectSegment(val, pathSegment{idx: idx, index: true})
+	}
+	return nil, false
+}
@@ -0,0 +1,70 @@
+package sigma
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var mapEventJSON = `
+{
+	"process": {
+		"name": "cmd.exe",
+		"args": ["/c", "whoami"],
+		"parent": {"name": "explorer.exe"}
+	},
+	"dns": {"answers": [{"data": "10.0.0.1"}, {"data": "10.0.0.2"}]},
+	"labels": {"app.kubernetes.io/name": "api"},
+	"host.name": "flat-key",
+	"message": "user logged in",
+	"tags": ["a", "b"]
+}
+`
+
+func TestMapEventSelect(t *testing.T) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(mapEventJSON), &data); err != nil {
+		t.Fatal(err)
+	}
+	e := NewMapEvent(data)
+	cases := []struct {
+		Key      string
+		Expected interface{}
+		Found    bool
+	}{
+		{Key: "process.name", Expected: "cmd.exe", Found: true},
+		{Key: "process.parent.name", Expected: "explorer.exe", Found: true},
+		{Key: "process.args[1]", Expected: "whoami", Found: true},
+		{Key: "process.args.0", Expected: "/c", Found: true},
+		{Key: "process.args[2]", Found: false},
+		{Key: "dns.answers[1].data", Expected: "10.0.0.2", Found: true},
+		{Key: `labels.app\.kubernetes\.io/name`, Expected: "api", Found: true},
+		{Key: "labels.app.kubernetes.io/name", Found: false},
+		{Key: "host.name", Expected: "flat-key", Found: true},
+		{Key: "process.name.first", Found: false},
+		{Key: "missing", Found: false},
+	}
+	for i, c := range cases {
+		val, ok := e.Select(c.Key)
+		if ok != c.Found {
+			t.Fatalf("case %d: %s expected found %t got %t", i, c.Key, c.Found, ok)
+		}
+		if ok && !reflect.DeepEqual(val, c.Expected) {
+			t.Fatalf("case %d: %s expected %v got %v", i, c.Key, c.Expected, val)
+		}
+	}
+}
+
+func TestMapEventKeywords(t *testing.T) {
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(mapEventJSON), &data); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := NewMapEvent(data).Keywords(); ok {
+		t.Fatal("keywords should not be supported without keyword fields")
+	}
+	kw, ok := NewMapEvent(data, "message", "tags", "missing").Keywords()
+	if !ok || !reflect.DeepEqual(kw, []string{"user logged in", "a", "b"}) {
+		t.Fatalf("unexpected keywords %q", kw)
+	}
+}
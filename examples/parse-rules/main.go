@@ -53,13 +53,8 @@ func main() {
 	log.Printf("Got %d rules from yaml\n", len(rules))
 	log.Println("Parsing rules into AST")
 	c := &counts{}
-loop:
 	for _, raw := range rules {
 		log.Print(raw.Path)
-		if raw.Multipart {
-			c.unsupported++
-			continue loop
-		}
 		_, err := sigma.NewTree(raw)
 		if err != nil {
 			switch err.(type) {
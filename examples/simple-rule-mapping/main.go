@@ -8,7 +8,6 @@ import (
 	"os"
 	"strings"
 
-	"github.com/markuskont/datamodels"
 	"github.com/markuskont/go-sigma-rule-engine"
 )
 
@@ -69,12 +68,12 @@ func main() {
 			log.Println(err)
 		}
 
-		var obj datamodels.Map
+		var obj map[string]interface{}
 		if err := json.Unmarshal(jsonStr, &obj); err != nil {
 			log.Println(err)
 		}
 
-		if results, ok := ruleset.EvalAll(obj); ok && len(results) > 0 {
+		if results, ok := ruleset.EvalAll(sigma.NewMapEvent(obj)); ok && len(results) > 0 {
 			obj["sigma_results"] = results
 			if err != nil {
 				log.Println(err)
@@ -8,7 +8,6 @@ import (
 	"os"
 	"strings"
 
-	"github.com/markuskont/datamodels"
 	"github.com/markuskont/go-sigma-rule-engine"
 )
 
@@ -34,12 +33,12 @@ func main() {
 	output := os.Stdout
 loop:
 	for scanner.Scan() {
-		var obj datamodels.Map
+		var obj map[string]interface{}
 		if err := json.Unmarshal(scanner.Bytes(), &obj); err != nil {
 			log.Println(err)
 			continue loop
 		}
-		if results, ok := ruleset.EvalAll(obj); ok && len(results) > 0 {
+		if results, ok := ruleset.EvalAll(sigma.NewMapEvent(obj)); ok && len(results) > 0 {
 			obj["sigma_results"] = results
 			encoded, err := json.Marshal(obj)
 			if err != nil {
@@ -9,7 +9,6 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/markuskont/datamodels"
 	"github.com/markuskont/go-sigma-rule-engine"
 )
 
@@ -51,12 +50,12 @@ func main() {
 			output := os.Stdout
 		loop:
 			for data := range ch {
-				var obj datamodels.Map
+				var obj map[string]interface{}
 				if err := json.Unmarshal(data, &obj); err != nil {
 					log.Println(err)
 					continue loop
 				}
-				if results, ok := ruleset.EvalAll(obj); ok && len(results) > 0 {
+				if results, ok := ruleset.EvalAll(sigma.NewMapEvent(obj)); ok && len(results) > 0 {
 					obj["sigma_results"] = results
 					encoded, err := json.Marshal(obj)
 					if err != nil {
@@ -0,0 +1,106 @@
+package sigma
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SelectionFieldRefItem compares event field to values of other fields in the same event
+// Refs are joined by logical disjunction, or conjunction when All is set
+type SelectionFieldRefItem struct {
+	Key       string
+	Modifiers []string
+	Refs      []string
+	Mod       TextPatternModifier
+	All       bool
+	// Lowercase enables case insensitive comparison
+	Lowercase bool
+}
+
+// match compares val against referenced fields
+// Referenced field that is missing or not a string never matches
+func (f SelectionFieldRefItem) match(val string, msg Event) bool {
+	if f.Lowercase {
+		val = strings.ToLower(val)
+	}
+	for _, ref := range f.Refs {
+		refVal, ok := msg.Select(ref)
+		var match bool
+		if ok {
+			if s, ok := stringValue(refVal); ok {
+				if f.Lowercase {
+					s = strings.ToLower(s)
+				}
+				match = compareFieldRef(f.Mod, val, s)
+			}
+		}
+		if match && !f.All {
+			return true
+		}
+		if !match && f.All {
+			return false
+		}
+	}
+	return f.All
+}
+
+func compareFieldRef(mod TextPatternModifier, val, ref string) bool {
+	switch mod {
+	case TextPatternPrefix:
+		return strings.HasPrefix(val, ref)
+	case TextPatternSuffix:
+		return strings.HasSuffix(val, ref)
+	case TextPatternContains:
+		return strings.Contains(val, ref)
+	default:
+		return val == ref
+	}
+}
+
+func newSelectionFieldRefItem(
+	key string,
+	mod TextPatternModifier,
+	lower, all bool,
+	pattern interface{},
+) (SelectionFieldRefItem, error) {
+	switch mod {
+	case TextPatternNone, TextPatternPrefix, TextPatternSuffix, TextPatternContains:
+	default:
+		return SelectionFieldRefItem{}, fmt.Errorf(
+			"selection key %s: fieldref can only be combined with startswith, endswith and contains", key)
+	}
+	refs, ok := pattern.([]interface{})
+	if !ok {
+		refs = []interface{}{pattern}
+	}
+	item := SelectionFieldRefItem{
+		Key:       key,
+		Refs:      castIfaceToString(refs),
+		Mod:       mod,
+		All:       all,
+		Lowercase: lower,
+	}
+	if len(item.Refs) == 0 {
+		return item, fmt.Errorf("selection key %s: fieldref is missing field names", key)
+	}
+	return item, nil
+}
+
+// stringValue converts event value to string for string matching
+// JSON numbers and booleans are included, as they are commonly compared to string patterns
+func stringValue(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		// JSON numbers are all by spec float64 values
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
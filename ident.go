@@ -3,8 +3,8 @@ package sigma
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"reflect"
-	"strconv"
 	"strings"
 )
 
@@ -60,12 +60,12 @@ func reflectIdentKind(data interface{}) identType {
 	}
 }
 
-func newRuleFromIdent(rule interface{}, kind identType, noCollapseWS bool) (Branch, error) {
+func newRuleFromIdent(rule interface{}, kind identType, noCollapseWS bool, transforms ruleTransformations) (Branch, error) {
 	switch kind {
 	case identKeyword:
 		return NewKeyword(rule, noCollapseWS)
 	case identSelection:
-		return NewSelectionBranch(rule, noCollapseWS)
+		return newSelectionBranch(rule, noCollapseWS, transforms)
 	}
 	return nil, fmt.Errorf("unknown rule kind, should be keyword or selection")
 }
@@ -93,7 +93,7 @@ func (k Keyword) Match(msg Event) (bool, bool) {
 func NewKeyword(expr interface{}, noCollapseWS bool) (*Keyword, error) {
 	switch val := expr.(type) {
 	case []string:
-		return newStringKeyword(TextPatternKeyword, false, noCollapseWS, val...)
+		return newStringKeyword(TextPatternKeyword, true, noCollapseWS, val...)
 	case []interface{}:
 		k, ok := isSameKind(val)
 		if !ok {
@@ -106,7 +106,7 @@ func NewKeyword(expr interface{}, noCollapseWS bool) (*Keyword, error) {
 		}
 		switch v := k; {
 		case v == reflect.String:
-			return newStringKeyword(TextPatternKeyword, false, noCollapseWS, castIfaceToString(val)...)
+			return newStringKeyword(TextPatternKeyword, true, noCollapseWS, castIfaceToString(val)...)
 		default:
 			return nil, ErrInvalidKind{
 				Kind:     v,
@@ -131,127 +131,232 @@ func newStringKeyword(mod TextPatternModifier, lower, noCollapseWS bool, pattern
 }
 
 type SelectionNumItem struct {
-	Key     string
-	Pattern NumMatcher
+	Key       string
+	Modifiers []string
+	Pattern   NumMatcher
 }
 
 type SelectionStringItem struct {
-	Key     string
-	Pattern StringMatcher
+	Key       string
+	Modifiers []string
+	Pattern   StringMatcher
+}
+
+type SelectionNetItem struct {
+	Key       string
+	Modifiers []string
+	Pattern   NetMatcher
+}
+
+// SelectionExistsItem checks field presence rather than value
+type SelectionExistsItem struct {
+	Key       string
+	Modifiers []string
+	// Exists is the expected presence of field
+	Exists bool
+	// Null also accepts a field that is present with nil value, used for field: null
+	Null bool
+}
+
+// SelectionBoolItem matches boolean values, event value must match one of Values
+type SelectionBoolItem struct {
+	Key       string
+	Modifiers []string
+	Values    []bool
 }
 
 type Selection struct {
+	E []SelectionExistsItem
 	N []SelectionNumItem
 	S []SelectionStringItem
+	I []SelectionNetItem
+	B []SelectionBoolItem
+	F []SelectionFieldRefItem
 	stats
 }
 
 // Match implements Matcher
 // TODO - numeric and boolean pattern match
 func (s Selection) Match(msg Event) (bool, bool) {
+	for _, v := range s.E {
+		// missing field is a valid answer here, so these items are always applicable
+		val, ok := msg.Select(v.Key)
+		if v.Null && ok && val == nil {
+			continue
+		}
+		if ok != v.Exists {
+			return false, true
+		}
+	}
 	for _, v := range s.N {
 		val, ok := msg.Select(v.Key)
 		if !ok {
 			return false, false
 		}
-		switch vt := val.(type) {
-		case string:
-			n, err := strconv.Atoi(vt)
-			if err != nil {
-				// TODO - better debugging
-				return false, true
-			}
-			if !v.Pattern.NumMatch(n) {
-				return false, true
-			}
-		case json.Number:
-			n, err := vt.Int64()
-			if err != nil {
-				// TODO - better debugging
-				return false, true
-			}
-			if !v.Pattern.NumMatch(int(n)) {
-				return false, true
-			}
-		case float64:
-			// JSON numbers are all by spec float64 values
-			if !v.Pattern.NumMatch(int(vt)) {
-				return false, true
-			}
-		case int:
-			// JSON numbers are all by spec float64 values
-			if !v.Pattern.NumMatch(vt) {
-				return false, true
-			}
-		case int64:
-			// JSON numbers are all by spec float64 values
-			if !v.Pattern.NumMatch(int(vt)) {
-				return false, true
-			}
-		case int32:
-			// JSON numbers are all by spec float64 values
-			if !v.Pattern.NumMatch(int(vt)) {
-				return false, true
-			}
-		case uint:
-			// JSON numbers are all by spec float64 values
-			if !v.Pattern.NumMatch(int(vt)) {
-				return false, true
-			}
-		case uint32:
-			// JSON numbers are all by spec float64 values
-			if !v.Pattern.NumMatch(int(vt)) {
-				return false, true
-			}
-		case uint64:
-			// JSON numbers are all by spec float64 values
-			if !v.Pattern.NumMatch(int(vt)) {
-				return false, true
+		// numeric strings and json.Number values are converted, anything else is a mismatch
+		if !s.matchValue(val, func(elem interface{}) (bool, bool) {
+			n, ok := NewNumber(elem)
+			if !ok {
+				_, isString := elem.(string)
+				return false, isString
 			}
+			return v.Pattern.NumMatch(n), true
+		}) {
+			return false, true
 		}
 	}
+	// items on the same field are next to each other, so their value is lowercased once
+	var lower lowerCache
 	for _, v := range s.S {
 		val, ok := msg.Select(v.Key)
 		if !ok {
 			return false, false
 		}
-		switch vt := val.(type) {
-		case string:
-			if !v.Pattern.StringMatch(vt) {
-				return false, true
+		if !s.matchValue(val, func(elem interface{}) (bool, bool) {
+			str, ok := stringValue(elem)
+			if !ok {
+				return false, false
 			}
-		case json.Number:
-			if !v.Pattern.StringMatch(vt.String()) {
-				return false, true
+			return lower.stringMatch(v.Pattern, str), true
+		}) {
+			return false, true
+		}
+	}
+	for _, v := range s.F {
+		val, ok := msg.Select(v.Key)
+		if !ok {
+			return false, false
+		}
+		if !s.matchValue(val, func(elem interface{}) (bool, bool) {
+			str, ok := stringValue(elem)
+			if !ok {
+				return false, false
 			}
-		case float64:
-			// TODO - tmp hack that also loses floating point accuracy
-			if !v.Pattern.StringMatch(strconv.Itoa(int(vt))) {
-				return false, true
+			return v.match(str, msg), true
+		}) {
+			return false, true
+		}
+	}
+	for _, v := range s.B {
+		val, ok := msg.Select(v.Key)
+		if !ok {
+			return false, false
+		}
+		if !s.matchValue(val, func(elem interface{}) (bool, bool) {
+			b, ok := castToBool(elem)
+			if !ok {
+				_, isString := elem.(string)
+				return false, isString
 			}
-		default:
-			s.incrementMismatchCount()
+			return containsBool(v.Values, b), true
+		}) {
+			return false, true
+		}
+	}
+	for _, v := range s.I {
+		val, ok := msg.Select(v.Key)
+		if !ok {
+			return false, false
+		}
+		if !s.matchValue(val, func(elem interface{}) (bool, bool) {
+			addr, ok := castToAddr(elem)
+			if !ok {
+				_, isString := elem.(string)
+				return false, isString
+			}
+			return v.Pattern.NetMatch(addr), true
+		}) {
 			return false, true
 		}
 	}
 	return true, true
 }
 
+// matchValue applies fn to a scalar value, or to every element of an array value
+// Array matches if any element matches, so modifiers like all are evaluated per element
+// fn reports whether value had a compatible type, mismatch is counted if no value did
+func (s Selection) matchValue(val interface{}, fn func(interface{}) (bool, bool)) bool {
+	elems, isArray := arrayValue(val)
+	if !isArray {
+		match, typed := fn(val)
+		if !typed {
+			s.incrementMismatchCount()
+		}
+		return match
+	}
+	var anyTyped bool
+	for _, elem := range elems {
+		match, typed := fn(elem)
+		if match {
+			return true
+		}
+		anyTyped = anyTyped || typed
+	}
+	if len(elems) > 0 && !anyTyped {
+		s.incrementMismatchCount()
+	}
+	return false
+}
+
+// arrayValue returns elements of array event values
+// byte slices, such as IP addresses, are not considered arrays
+func arrayValue(val interface{}) ([]interface{}, bool) {
+	switch v := val.(type) {
+	case []interface{}:
+		return v, true
+	case []string:
+		tx := make([]interface{}, len(v))
+		for i, item := range v {
+			tx[i] = item
+		}
+		return tx, true
+	case []byte, net.IP, nil:
+		return nil, false
+	case string, float64, int, int64, bool, json.Number:
+		// common scalars are handled without reflection, as this runs for every matched value
+		return nil, false
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	tx := make([]interface{}, rv.Len())
+	for i := range tx {
+		tx[i] = rv.Index(i).Interface()
+	}
+	return tx, true
+}
+
 func (s *Selection) incrementMismatchCount() *Selection {
 	s.stats.TypeMismatchCount++
 	return s
 }
 
-func newSelectionFromMap(expr map[string]interface{}, noCollapseWS bool) (*Selection, error) {
+func newSelectionFromMap(expr map[string]interface{}, noCollapseWS bool, transforms ruleTransformations) (*Selection, error) {
+	expr, err := transforms.mapSelection(expr)
+	if err != nil {
+		return nil, err
+	}
 	sel := &Selection{S: make([]SelectionStringItem, 0)}
-	for key, pattern := range expr {
+	for _, key := range sortedKeys(expr) {
+		pattern := expr[key]
+		// modifiers as written in rule, kept for debugging
+		var mods []string
 		var mod TextPatternModifier
-		var all bool
+		var all, cased, cidr, exists, fieldref bool
+		// numeric comparison operator, TokBegin when not set
+		op := TokBegin
+		// value transforms, applied to patterns in the order they were given
+		var valueTransforms []valueTransform
+		// regular expression flags, only valid with re modifier
+		var reFlags string
 		if strings.Contains(key, "|") {
 			bits := strings.Split(key, "|")
+			mods = bits[1:]
 			// allow support for longer chaining later on; simplifies specifier validation as well (I think)
 			for _, curBit := range bits[1:] {
-				// excepting 'all', the supported modifiers are mutually exclusive; last one wins
+				// matcher types (startswith, endswith, re, contains) are mutually exclusive; last one wins
+				// other modifiers can be combined with them
 				switch curBit {
 				case "startswith":
 					mod = TextPatternPrefix
@@ -263,39 +368,140 @@ func newSelectionFromMap(expr map[string]interface{}, noCollapseWS bool) (*Selec
 					mod = TextPatternContains
 				case "all":
 					all = true
+				case "cased":
+					cased = true
+				case "cidr":
+					cidr = true
+				case "exists":
+					exists = true
+				case "fieldref":
+					fieldref = true
+				case "lt":
+					op = TokOpLt
+				case "lte":
+					op = TokOpLte
+				case "gt":
+					op = TokOpGt
+				case "gte":
+					op = TokOpGte
+				case "i", "m", "s":
+					if !strings.Contains(reFlags, curBit) {
+						reFlags += curBit
+					}
 				default:
-					return nil, fmt.Errorf("selection key %s specifier %s invalid",
-						key, curBit)
+					t, ok := newValueTransform(curBit)
+					if !ok {
+						return nil, fmt.Errorf("selection key %s specifier %s invalid",
+							key, curBit)
+					}
+					valueTransforms = append(valueTransforms, t)
 				}
 			}
 			// strip off the specifier from the key so we can look it up correctly
 			key = bits[0]
 		}
+		if reFlags != "" {
+			if mod != TextPatternRegex {
+				return nil, fmt.Errorf("selection key %s specifier %s is only valid with re modifier",
+					key, reFlags)
+			}
+			valueTransforms = append(valueTransforms, regexFlagTransform(reFlags))
+		}
+		// string matching is case insensitive per spec, unless cased modifier is used
+		// regular expressions are case sensitive, as they have their own flags for that
+		lower := !cased && mod != TextPatternRegex
+		// value transforms only apply to string patterns
+		if len(valueTransforms) > 0 {
+			var typed string
+			switch {
+			case exists:
+				typed = "exists"
+			case fieldref:
+				typed = "fieldref"
+			case cidr:
+				typed = "cidr"
+			case op != TokBegin:
+				typed = "numeric comparison"
+			}
+			if typed != "" {
+				return nil, fmt.Errorf("selection key %s: %s can not be combined with value modifiers", key, typed)
+			}
+		}
+		if exists {
+			expected, ok := pattern.(bool)
+			if !ok {
+				return nil, fmt.Errorf("selection key %s: exists modifier requires boolean value", key)
+			}
+			sel.E = append(sel.E, SelectionExistsItem{Key: key, Modifiers: mods, Exists: expected})
+			continue
+		}
+		if fieldref {
+			item, err := newSelectionFieldRefItem(key, mod, lower, all, pattern)
+			if err != nil {
+				return nil, err
+			}
+			item.Modifiers = mods
+			sel.F = append(sel.F, item)
+			continue
+		}
+		if cidr {
+			// prefixes are parsed here, so broken networks fail the rule rather than every event
+			prefixes, ok := pattern.([]interface{})
+			if !ok {
+				prefixes = []interface{}{pattern}
+			}
+			m, err := NewNetMatcher(castIfaceToString(prefixes)...)
+			if err != nil {
+				return nil, fmt.Errorf("selection key %s: %s", key, err)
+			}
+			sel.I = append(sel.I, SelectionNetItem{Key: key, Modifiers: mods, Pattern: m})
+			continue
+		}
+		if op != TokBegin {
+			// range comparisons only make sense for numbers, values can also be numeric strings
+			nums, err := castToNumbers(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("selection key %s: %s", key, err)
+			}
+			m, err := NewNumMatcher(op, nums...)
+			if err != nil {
+				return nil, err
+			}
+			sel.N = append(sel.N, SelectionNumItem{Key: key, Modifiers: mods, Pattern: m})
+			continue
+		}
 		switch pat := pattern.(type) {
+		case nil:
+			// null value matches absent fields and fields explicitly set to null
+			sel.E = append(sel.E, SelectionExistsItem{Key: key, Modifiers: mods, Null: true})
 		case string:
-			m, err := NewStringMatcher(mod, false, all, noCollapseWS, pat)
+			m, err := newTransformedStringMatcher(mod, lower, all, noCollapseWS, valueTransforms, pat)
 			if err != nil {
-				return nil, err
+				return nil, withRegexField(err, key)
 			}
-			sel.S = append(sel.S, SelectionStringItem{Key: key, Pattern: m})
-		case int:
-			m, err := NewNumMatcher(pat)
+			sel.S = append(sel.S, SelectionStringItem{Key: key, Modifiers: mods, Pattern: m})
+		case bool:
+			sel.B = append(sel.B, SelectionBoolItem{Key: key, Modifiers: mods, Values: []bool{pat}})
+		case int, int64, uint64, float64:
+			n, _ := NewNumber(pat)
+			m, err := NewNumMatcher(TokOpEq, n)
 			if err != nil {
 				return nil, err
 			}
-			sel.N = func() []SelectionNumItem {
-				item := SelectionNumItem{
-					Key: key, Pattern: m,
-				}
-				if sel.N == nil {
-					sel.N = []SelectionNumItem{item}
-				}
-				return append(sel.N, item)
-			}()
+			sel.N = append(sel.N, SelectionNumItem{Key: key, Modifiers: mods, Pattern: m})
 		case []interface{}:
 			// TODO - move this part to separate function and reuse in NewKeyword
 			k, ok := isSameKind(pat)
 			if !ok {
+				// integers and floats are commonly mixed in lists
+				if nums, err := castToNumbers(pat); err == nil {
+					m, err := NewNumMatcher(TokOpEq, nums...)
+					if err != nil {
+						return nil, err
+					}
+					sel.N = append(sel.N, SelectionNumItem{Key: key, Modifiers: mods, Pattern: m})
+					continue
+				}
 				return nil, ErrInvalidKind{
 					Kind:     reflect.Array,
 					T:        identKeyword,
@@ -304,26 +510,28 @@ func newSelectionFromMap(expr map[string]interface{}, noCollapseWS bool) (*Selec
 				}
 			}
 			switch k {
+			case reflect.Bool:
+				values := make([]bool, 0, len(pat))
+				for _, v := range pat {
+					values = append(values, v.(bool))
+				}
+				sel.B = append(sel.B, SelectionBoolItem{Key: key, Modifiers: mods, Values: values})
 			case reflect.String:
-				m, err := NewStringMatcher(mod, false, all, noCollapseWS, castIfaceToString(pat)...)
+				m, err := newTransformedStringMatcher(mod, lower, all, noCollapseWS, valueTransforms, castIfaceToString(pat)...)
+				if err != nil {
+					return nil, withRegexField(err, key)
+				}
+				sel.S = append(sel.S, SelectionStringItem{Key: key, Modifiers: mods, Pattern: m})
+			case reflect.Int, reflect.Int64, reflect.Uint64, reflect.Float64:
+				nums, err := castToNumbers(pat)
 				if err != nil {
 					return nil, err
 				}
-				sel.S = append(sel.S, SelectionStringItem{Key: key, Pattern: m})
-			case reflect.Int:
-				m, err := NewNumMatcher(castIfaceToInt(pat)...)
+				m, err := NewNumMatcher(TokOpEq, nums...)
 				if err != nil {
 					return nil, err
 				}
-				sel.N = func() []SelectionNumItem {
-					item := SelectionNumItem{
-						Key: key, Pattern: m,
-					}
-					if sel.N == nil {
-						sel.N = []SelectionNumItem{item}
-					}
-					return append(sel.N, item)
-				}()
+				sel.N = append(sel.N, SelectionNumItem{Key: key, Modifiers: mods, Pattern: m})
 			default:
 				return nil, ErrInvalidKind{
 					Kind:     k,
@@ -347,12 +555,25 @@ func newSelectionFromMap(expr map[string]interface{}, noCollapseWS bool) (*Selec
 	return sel, nil
 }
 
+// withRegexField annotates regular expression errors with the field they were defined for
+func withRegexField(err error, field string) error {
+	if re, ok := err.(ErrInvalidRegex); ok {
+		re.Field = field
+		return re
+	}
+	return err
+}
+
 func NewSelectionBranch(expr interface{}, noCollapseWS bool) (Branch, error) {
+	return newSelectionBranch(expr, noCollapseWS, nil)
+}
+
+func newSelectionBranch(expr interface{}, noCollapseWS bool, transforms ruleTransformations) (Branch, error) {
 	switch v := expr.(type) {
 	case []interface{}:
 		selections := make([]Branch, 0)
 		for _, item := range v {
-			b, err := NewSelectionBranch(item, noCollapseWS)
+			b, err := newSelectionBranch(item, noCollapseWS, transforms)
 			if err != nil {
 				return nil, err
 			}
@@ -360,7 +581,7 @@ func NewSelectionBranch(expr interface{}, noCollapseWS bool) (Branch, error) {
 		}
 		return NodeSimpleOr(selections).Reduce(), nil
 	case map[interface{}]interface{}:
-		return newSelectionFromMap(cleanUpInterfaceMap(v), noCollapseWS)
+		return newSelectionFromMap(cleanUpInterfaceMap(v), noCollapseWS, transforms)
 	default:
 		return nil, ErrInvalidKind{
 			Kind:     reflect.TypeOf(expr).Kind(),
@@ -397,14 +618,59 @@ func castIfaceToString(items []interface{}) []string {
 	return tx
 }
 
-func castIfaceToInt(items []interface{}) []int {
-	tx := make([]int, 0)
+// castToNumbers converts a numeric rule value or list of values to Number objects
+// numeric strings are accepted, as rule writers often quote them
+func castToNumbers(pattern interface{}) ([]Number, error) {
+	items, ok := pattern.([]interface{})
+	if !ok {
+		items = []interface{}{pattern}
+	}
+	tx := make([]Number, 0, len(items))
 	for _, val := range items {
-		if n, ok := val.(int); ok {
-			tx = append(tx, n)
+		n, ok := NewNumber(val)
+		if !ok {
+			return nil, fmt.Errorf("value %v is not numeric", val)
 		}
+		tx = append(tx, n)
 	}
-	return tx
+	return tx, nil
+}
+
+// castToBool converts event values to boolean
+// Strings "true" and "false" are accepted in any case, numbers 1 and 0 as well as numeric strings
+// Second return value is false if value can not be interpreted as boolean
+func castToBool(val interface{}) (bool, bool) {
+	if b, ok := val.(bool); ok {
+		return b, true
+	}
+	if str, ok := val.(string); ok {
+		switch strings.ToLower(strings.TrimSpace(str)) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	n, ok := NewNumber(val)
+	if !ok {
+		return false, false
+	}
+	if c, ok := n.Compare(Number{Kind: NumberInt, Int: 1}); ok && c == 0 {
+		return true, true
+	}
+	if c, ok := n.Compare(Number{Kind: NumberInt, Int: 0}); ok && c == 0 {
+		return false, true
+	}
+	return false, false
+}
+
+func containsBool(values []bool, b bool) bool {
+	for _, v := range values {
+		if v == b {
+			return true
+		}
+	}
+	return false
 }
 
 // Yaml can have non-string keys, so go-yaml unmarshals to map[interface{}]interface{}
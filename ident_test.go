@@ -349,7 +349,7 @@ func TestParseIdent(t *testing.T) {
 		var items, j int
 		keywords := make([]Matcher, 0)
 		selections := make([]Matcher, 0)
-		for item := range l.items {
+		for _, item := range l.items {
 			switch item.T {
 			case TokIdentifier:
 				val, ok := r.Detection[item.Val]
@@ -0,0 +1,288 @@
+package sigma
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// JSONEvent implements Event over raw JSON object without decoding it up front
+// Fields are located by scanning raw bytes when selected, and only the selected value is decoded
+// Path syntax is the same as for MapEvent
+// Selected values are cached for the lifetime of the event, so JSONEvent must not be shared between goroutines
+// Data must not be modified while event is in use
+type JSONEvent struct {
+	Data []byte
+	// KeywordFields lists paths of fields that keyword rules are matched against
+	// Keywords are not supported if empty
+	KeywordFields []string
+
+	cache map[string]jsonCacheItem
+}
+
+type jsonCacheItem struct {
+	val interface{}
+	ok  bool
+}
+
+// NewJSONEvent wraps raw JSON object into an Event
+func NewJSONEvent(data []byte, keywordFields ...string) *JSONEvent {
+	return &JSONEvent{Data: data, KeywordFields: keywordFields}
+}
+
+// Keywords implements Keyworder
+// Array fields contribute every element
+func (j *JSONEvent) Keywords() ([]string, bool) {
+	if len(j.KeywordFields) == 0 {
+		return nil, false
+	}
+	tx := make([]string, 0, len(j.KeywordFields))
+	for _, field := range j.KeywordFields {
+		val, ok := j.Select(field)
+		if !ok {
+			continue
+		}
+		if elems, ok := arrayValue(val); ok {
+			for _, elem := range elems {
+				if str, ok := stringValue(elem); ok {
+					tx = append(tx, str)
+				}
+			}
+			continue
+		}
+		if str, ok := stringValue(val); ok {
+			tx = append(tx, str)
+		}
+	}
+	return tx, len(tx) > 0
+}
+
+// Select implements Selector
+// Numbers are returned as json.Number, nested objects and arrays are decoded on demand
+func (j *JSONEvent) Select(key string) (interface{}, bool) {
+	if item, ok := j.cache[key]; ok {
+		return item.val, item.ok
+	}
+	val, ok := j.lookup(key)
+	if j.cache == nil {
+		j.cache = make(map[string]jsonCacheItem)
+	}
+	j.cache[key] = jsonCacheItem{val: val, ok: ok}
+	return val, ok
+}
+
+func (j *JSONEvent) lookup(key string) (interface{}, bool) {
+	root := skipJSONSpace(j.Data, 0)
+	// key that is present as is takes precedence, same as in MapEvent
+	if pos, ok := findJSONKey(j.Data, root, key); ok {
+		return decodeJSONValue(j.Data, pos)
+	}
+	if !strings.ContainsAny(key, `.[\`) {
+		return nil, false
+	}
+	pos := root
+	for _, seg := range splitFieldPath(key) {
+		next, ok := selectJSONSegment(j.Data, pos, seg)
+		if !ok {
+			return nil, false
+		}
+		pos = next
+	}
+	return decodeJSONValue(j.Data, pos)
+}
+
+func selectJSONSegment(data []byte, pos int, seg pathSegment) (int, bool) {
+	if pos >= len(data) {
+		return 0, false
+	}
+	switch {
+	case seg.index:
+		return findJSONIndex(data, pos, seg.idx)
+	case data[pos] == '{':
+		return findJSONKey(data, pos, seg.key)
+	case data[pos] == '[':
+		// numeric segment can also index an array, e.g. args.0
+		if idx, err := strconv.Atoi(seg.key); err == nil {
+			return findJSONIndex(data, pos, idx)
+		}
+	}
+	return 0, false
+}
+
+// findJSONKey returns position of value for key in object starting at pos
+// Last value wins for duplicate keys, same as with encoding/json
+func findJSONKey(data []byte, pos int, key string) (int, bool) {
+	if pos >= len(data) || data[pos] != '{' {
+		return 0, false
+	}
+	found := -1
+	i := skipJSONSpace(data, pos+1)
+	for i < len(data) && data[i] != '}' {
+		if data[i] != '"' {
+			return 0, false
+		}
+		end, ok := skipJSONString(data, i)
+		if !ok {
+			return 0, false
+		}
+		match := jsonKeyEquals(data[i:end], key)
+		i = skipJSONSpace(data, end)
+		if i >= len(data) || data[i] != ':' {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i+1)
+		if match {
+			found = i
+		}
+		if i, ok = skipJSONValue(data, i); !ok {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i)
+		if i < len(data) && data[i] == ',' {
+			i = skipJSONSpace(data, i+1)
+		}
+	}
+	return found, found >= 0
+}
+
+// findJSONIndex returns position of n-th element in array starting at pos
+func findJSONIndex(data []byte, pos, idx int) (int, bool) {
+	if pos >= len(data) || data[pos] != '[' || idx < 0 {
+		return 0, false
+	}
+	i := skipJSONSpace(data, pos+1)
+	for n := 0; i < len(data) && data[i] != ']'; n++ {
+		if n == idx {
+			return i, true
+		}
+		var ok bool
+		if i, ok = skipJSONValue(data, i); !ok {
+			return 0, false
+		}
+		i = skipJSONSpace(data, i)
+		if i < len(data) && data[i] == ',' {
+			i = skipJSONSpace(data, i+1)
+		}
+	}
+	return 0, false
+}
+
+// jsonKeyEquals compares quoted raw key to key, escaped keys are decoded before comparison
+func jsonKeyEquals(raw []byte, key string) bool {
+	inner := raw[1 : len(raw)-1]
+	if bytes.IndexByte(inner, '\\') < 0 {
+		return string(inner) == key
+	}
+	var decoded string
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return false
+	}
+	return decoded == key
+}
+
+// decodeJSONValue decodes a single value starting at pos
+func decodeJSONValue(data []byte, pos int) (interface{}, bool) {
+	end, ok := skipJSONValue(data, pos)
+	if !ok {
+		return nil, false
+	}
+	raw := data[pos:end]
+	switch raw[0] {
+	case '"':
+		inner := raw[1 : len(raw)-1]
+		if bytes.IndexByte(inner, '\\') < 0 {
+			return string(inner), true
+		}
+		var str string
+		if err := json.Unmarshal(raw, &str); err != nil {
+			return nil, false
+		}
+		return str, true
+	case 't':
+		return true, string(raw) == "true"
+	case 'f':
+		return false, string(raw) == "false"
+	case 'n':
+		return nil, string(raw) == "null"
+	case '{', '[':
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var val interface{}
+		if err := dec.Decode(&val); err != nil {
+			return nil, false
+		}
+		return val, true
+	default:
+		// skipping only finds where literal ends, malformed numbers must not reach numeric matchers
+		if !json.Valid(raw) {
+			return nil, false
+		}
+		return json.Number(raw), true
+	}
+}
+
+func skipJSONSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipJSONString returns position after closing quote of string starting at i
+func skipJSONString(data []byte, i int) (int, bool) {
+	for i++; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// skipJSONValue returns position after value starting at i
+func skipJSONValue(data []byte, i int) (int, bool) {
+	if i >= len(data) {
+		return 0, false
+	}
+	switch data[i] {
+	case '"':
+		return skipJSONString(data, i)
+	case '{', '[':
+		depth := 0
+		for ; i < len(data); i++ {
+			switch data[i] {
+			case '"':
+				end, ok := skipJSONString(data, i)
+				if !ok {
+					return 0, false
+				}
+				i = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, true
+				}
+			}
+		}
+		return 0, false
+	default:
+		start := i
+		for ; i < len(data); i++ {
+			switch data[i] {
+			case ',', '}', ']', ' ', '\t', '\n', '\r':
+				return i, i > start
+			}
+		}
+		return i, i > start
+	}
+}
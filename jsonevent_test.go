@@ -0,0 +1,132 @@
+package sigma
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+var jsonEventData = `{
+	"process": {
+		"name": "cmd.exe",
+		"args": ["/c", "who\"ami"],
+		"parent": {"name": "explorer.exe", "pid": 1234}
+	},
+	"dns": {"answers": [{"data": "10.0.0.1"}, {"data": "10.0.0.2"}]},
+	"labels": {"app.kubernetes.io/name": "api"},
+	"host.name": "flat-key",
+	"escaped": "kéy",
+	"message": "user logged in",
+	"elevated": true,
+	"token": null,
+	"size": 1.5e3
+}`
+
+func TestJSONEventSelect(t *testing.T) {
+	e := NewJSONEvent([]byte(jsonEventData), "message")
+	cases := []struct {
+		Key      string
+		Expected interface{}
+		Found    bool
+	}{
+		{Key: "process.name", Expected: "cmd.exe", Found: true},
+		{Key: "process.parent.pid", Expected: json.Number("1234"), Found: true},
+		{Key: "process.args[1]", Expected: `who"ami`, Found: true},
+		{Key: "process.args.0", Expected: "/c", Found: true},
+		{Key: "process.args", Expected: []interface{}{"/c", `who"ami`}, Found: true},
+		{Key: "process.args[2]", Found: false},
+		{Key: "dns.answers[1].data", Expected: "10.0.0.2", Found: true},
+		{Key: `labels.app\.kubernetes\.io/name`, Expected: "api", Found: true},
+		{Key: "host.name", Expected: "flat-key", Found: true},
+		{Key: "escaped", Expected: "kéy", Found: true},
+		{Key: "elevated", Expected: true, Found: true},
+		{Key: "token", Expected: nil, Found: true},
+		{Key: "size", Expected: json.Number("1.5e3"), Found: true},
+		{Key: "process.name.first", Found: false},
+		{Key: "missing", Found: false},
+	}
+	// second pass is served from cache
+	for pass := 0; pass < 2; pass++ {
+		for i, c := range cases {
+			val, ok := e.Select(c.Key)
+			if ok != c.Found {
+				t.Fatalf("pass %d case %d: %s expected found %t got %t", pass, i, c.Key, c.Found, ok)
+			}
+			if ok && !reflect.DeepEqual(val, c.Expected) {
+				t.Fatalf("pass %d case %d: %s expected %#v got %#v", pass, i, c.Key, c.Expected, val)
+			}
+		}
+	}
+	if kw, ok := e.Keywords(); !ok || !reflect.DeepEqual(kw, []string{"user logged in"}) {
+		t.Fatalf("unexpected keywords %q", kw)
+	}
+	for _, broken := range []string{``, `[]`, `{"a": `, `{"a" "b"}`, `{"a": "b`, `{"a": 12abc}`, `{"a": 1.2.3}`, `{"a": -}`} {
+		if _, ok := NewJSONEvent([]byte(broken)).Select("a"); ok {
+			t.Fatalf("malformed input %q should not select a value", broken)
+		}
+	}
+
+	// duplicate keys resolve to last value, same as encoding/json
+	dup := []byte(`{"a": 1, "p": {"x": "first", "x": "last"}, "a": 2}`)
+	var decoded map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(dup))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	e = NewJSONEvent(dup)
+	if val, _ := e.Select("a"); val != decoded["a"] {
+		t.Fatalf("duplicate key selected %v, encoding/json decoded %v", val, decoded["a"])
+	}
+	if val, _ := e.Select("p.x"); val != "last" {
+		t.Fatalf("nested duplicate key selected %v", val)
+	}
+}
+
+func TestTreeParseJSONEvent(t *testing.T) {
+	for _, c := range parseTestCases {
+		var rule Rule
+		if err := yaml.Unmarshal([]byte(c.Rule), &rule); err != nil {
+			t.Fatalf("tree parse case %d failed to unmarshal yaml, %s", c.ID, err)
+		}
+		p, err := NewTree(RuleHandle{Rule: rule, NoCollapseWS: c.noCollapseWSNeg})
+		if err != nil {
+			t.Fatalf("tree parse case %d failed: %s", c.ID, err)
+		}
+		for i, c2 := range c.Pos {
+			if m, _ := p.Match(NewJSONEvent([]byte(c2))); !m {
+				t.Fatalf("json event case %d positive case %d did not match", c.ID, i)
+			}
+		}
+		for i, c2 := range c.Neg {
+			if m, _ := p.Match(NewJSONEvent([]byte(c2))); m {
+				t.Fatalf("json event case %d negative case %d matched", c.ID, i)
+			}
+		}
+	}
+}
+
+func BenchmarkJSONEventSelect(b *testing.B) {
+	data := []byte(jsonEventData)
+	for i := 0; i < b.N; i++ {
+		e := NewJSONEvent(data)
+		e.Select("process.parent.name")
+		e.Select("message")
+	}
+}
+
+func BenchmarkMapEventUnmarshalSelect(b *testing.B) {
+	data := []byte(jsonEventData)
+	for i := 0; i < b.N; i++ {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			b.Fatal(err)
+		}
+		e := NewMapEvent(obj)
+		e.Select("process.parent.name")
+		e.Select("message")
+	}
+}
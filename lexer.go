@@ -8,20 +8,21 @@ import (
 )
 
 type lexer struct {
-	input    string    // we'll store the string being parsed
-	start    int       // the position we started scanning
-	position int       // the current position of our scan
-	width    int       // we'll be using runes which can be double byte
-	items    chan Item // the channel we'll use to communicate between the lexer and the parser
+	input    string // we'll store the string being parsed
+	start    int    // the position we started scanning
+	position int    // the current position of our scan
+	width    int    // we'll be using runes which can be double byte
+	items    []Item // scanned items, collected by the parser
 }
 
-// lex creates a lexer and starts scanning the provided input.
+// lex creates a lexer and scans the provided input.
+// Scanning is synchronous, so nothing outlives the call when parser bails out early
 func lex(input string) *lexer {
 	l := &lexer{
 		input: input,
-		items: make(chan Item), // unbuffered
+		items: make([]Item, 0, 8),
 	}
-	go l.scan()
+	l.scan()
 	return l
 }
 
@@ -58,26 +59,25 @@ func (l *lexer) scan() {
 	for fn := lexCondition; fn != nil; {
 		fn = fn(l)
 	}
-	close(l.items)
 }
 
 func (l *lexer) unsuppf(format string, args ...interface{}) stateFn {
 	msg := fmt.Sprintf(format, args...)
-	l.items <- Item{T: TokUnsupp, Val: msg}
+	l.items = append(l.items, Item{T: TokUnsupp, Val: msg, Pos: l.start})
 	return nil
 }
 
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 	msg := fmt.Sprintf(format, args...)
-	l.items <- Item{T: TokErr, Val: msg}
+	l.items = append(l.items, Item{T: TokErr, Val: msg, Pos: l.start})
 	return nil
 }
 
-// emit sends a item over the channel so the parser can collect and manage
+// emit stores an item so the parser can collect and manage
 // each segment.
 func (l *lexer) emit(k Token) {
-	i := Item{T: k, Val: l.input[l.start:l.position]}
-	l.items <- i
+	i := Item{T: k, Val: l.input[l.start:l.position], Pos: l.start}
+	l.items = append(l.items, i)
 	l.ignore() // reset our scanner now that we've dispatched a segment
 }
 
@@ -90,10 +90,11 @@ type stateFn func(*lexer) stateFn
 // lexCondition scans what is expected to be text.
 func lexCondition(l *lexer) stateFn {
 	for {
-		if strings.HasPrefix(l.todo(), TokStOne.Literal()) {
+		// statements are only recognized at the start of a word
+		if l.position == l.start && hasStatementPrefix(l.todo(), TokStOne) {
 			return lexOneOf
 		}
-		if strings.HasPrefix(l.todo(), TokStAll.Literal()) {
+		if l.position == l.start && hasStatementPrefix(l.todo(), TokStAll) {
 			return lexAllOf
 		}
 		switch r := l.next(); {
@@ -111,6 +112,19 @@ func lexCondition(l *lexer) stateFn {
 	}
 }
 
+// hasStatementPrefix checks for 1 of / all of statement followed by whitespace or end of input
+func hasStatementPrefix(in string, t Token) bool {
+	lit := t.Literal()
+	if len(in) < len(lit) || !strings.EqualFold(in[:len(lit)], lit) {
+		return false
+	}
+	if len(in) == len(lit) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(in[len(lit):])
+	return unicode.IsSpace(r)
+}
+
 func lexStatement(l *lexer) stateFn {
 	return lexCondition
 }
@@ -127,8 +141,84 @@ func lexAllOf(l *lexer) stateFn {
 	return lexCondition
 }
 
+// lexAggs scans the aggregation expression that follows the pipe separator
+// for example, count(field) by group > 10
 func lexAggs(l *lexer) stateFn {
-	return l.unsuppf("aggregation not supported yet [%s]", l.input)
+	for {
+		switch r := l.next(); {
+		case r == eof:
+			l.emit(TokLitEof)
+			return nil
+		case unicode.IsSpace(r):
+			l.ignore()
+		case r == TokSepLpar.Rune():
+			l.emit(TokSepLpar)
+		case r == TokSepRpar.Rune():
+			l.emit(TokSepRpar)
+		case r == TokOpEq.Rune():
+			l.emit(TokOpEq)
+		case r == TokOpGt.Rune(), r == TokOpLt.Rune():
+			return lexAggOperator
+		case unicode.IsDigit(r):
+			return lexAggNumber
+		default:
+			return lexAggWord
+		}
+	}
+}
+
+func lexAggOperator(l *lexer) stateFn {
+	op := l.collected()
+	if strings.HasPrefix(l.todo(), TokOpEq.Literal()) {
+		l.next()
+	}
+	switch l.collected() {
+	case TokOpGt.Literal():
+		l.emit(TokOpGt)
+	case TokOpGte.Literal():
+		l.emit(TokOpGte)
+	case TokOpLt.Literal():
+		l.emit(TokOpLt)
+	case TokOpLte.Literal():
+		l.emit(TokOpLte)
+	default:
+		return l.errorf("invalid aggregation operator %s", op)
+	}
+	return lexAggs
+}
+
+func lexAggNumber(l *lexer) stateFn {
+	for {
+		r, width := utf8.DecodeRuneInString(l.todo())
+		if !unicode.IsDigit(r) && r != '.' {
+			l.emit(TokLitNum)
+			return lexAggs
+		}
+		l.position += width
+	}
+}
+
+func lexAggWord(l *lexer) stateFn {
+	for {
+		r, width := utf8.DecodeRuneInString(l.todo())
+		if r == utf8.RuneError || unicode.IsSpace(r) || strings.ContainsRune("()<>=", r) {
+			break
+		}
+		l.position += width
+	}
+	// aggregation function directly follows the pipe, same words are field names elsewhere
+	afterPipe := len(l.items) > 0 && l.items[len(l.items)-1].T == TokSepPipe
+	switch word := strings.ToLower(l.collected()); {
+	case afterPipe && (word == "sum" || word == "min" || word == "max" || word == "count" || word == "avg"):
+		l.emit(TokKeywordAgg)
+	case word == TokKeywordBy.Literal():
+		l.emit(TokKeywordBy)
+	case word == TokKeywordNear.Literal():
+		return l.unsuppf("near aggregation not supported yet [%s]", l.input)
+	default:
+		l.emit(TokIdentifier)
+	}
+	return lexAggs
 }
 
 func lexEOF(l *lexer) stateFn {
@@ -145,6 +235,12 @@ func lexPipe(l *lexer) stateFn {
 }
 
 func lexLpar(l *lexer) stateFn {
+	// emit any text we've accumulated, e.g. not(
+	if l.position-1 > l.start {
+		l.backup()
+		l.emit(checkKeyWord(l.collected()))
+		l.next()
+	}
 	l.emit(TokSepLpar)
 	return lexCondition
 }
@@ -214,8 +310,6 @@ func checkKeyWord(in string) Token {
 		return TokKeywordOr
 	case TokKeywordNot.Literal():
 		return TokKeywordNot
-	case "sum", "min", "max", "count", "avg":
-		return TokKeywordAgg
 	case TokIdentifierAll.Literal():
 		return TokIdentifierAll
 	case TokStOne.Literal():
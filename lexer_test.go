@@ -30,7 +30,30 @@ var LexPosCases = []LexTestCase{
 		Expr: "all of selection* and not 1 of filter* | count() > 10",
 		Tokens: []Token{
 			TokStAll, TokIdentifierWithWildcard, TokKeywordAnd, TokKeywordNot, TokStOne,
-			TokIdentifierWithWildcard, TokSepPipe, TokUnsupp, TokIdentifier, TokLitEof,
+			TokIdentifierWithWildcard, TokSepPipe, TokKeywordAgg, TokSepLpar, TokSepRpar,
+			TokOpGt, TokLitNum, TokLitEof,
+		},
+	},
+	{
+		Expr: "not(selection) and 1 of them or all of filter*",
+		Tokens: []Token{
+			TokKeywordNot, TokSepLpar, TokIdentifier, TokSepRpar, TokKeywordAnd, TokStOne,
+			TokIdentifierAll, TokKeywordOr, TokStAll, TokIdentifierWithWildcard, TokLitEof,
+		},
+	},
+	{
+		Expr: "selection | count(dst_port) by src_ip >= 10",
+		Tokens: []Token{
+			TokIdentifier, TokSepPipe, TokKeywordAgg, TokSepLpar, TokIdentifier, TokSepRpar,
+			TokKeywordBy, TokIdentifier, TokOpGte, TokLitNum, TokLitEof,
+		},
+	},
+	{
+		// aggregation functions are only keywords after pipe
+		Expr: "count and not max | count(max) by sum > 1",
+		Tokens: []Token{
+			TokIdentifier, TokKeywordAnd, TokKeywordNot, TokIdentifier, TokSepPipe, TokKeywordAgg,
+			TokSepLpar, TokIdentifier, TokSepRpar, TokKeywordBy, TokIdentifier, TokOpGt, TokLitNum, TokLitEof,
 		},
 	},
 }
@@ -39,7 +62,7 @@ func TestLex(t *testing.T) {
 	for j, c := range LexPosCases {
 		l := lex(c.Expr)
 		var i int
-		for item := range l.items {
+		for _, item := range l.items {
 			if item.T != c.Tokens[i] {
 				t.Fatalf(
 					"lex case %d expr %s failed on item %d expected %s got %s",
@@ -0,0 +1,103 @@
+package sigma
+
+import "strings"
+
+// Logsourcer is an optional interface for events that know their own logsource
+// With Config.RouteLogsource, Ruleset only evaluates rules that were written for logsource of such events
+type Logsourcer interface {
+	// Logsource implements Logsourcer
+	// Second return value is false if event logsource is not known, then all rules are evaluated
+	Logsource() (Logsource, bool)
+}
+
+// logsourceIndex maps logsource fields to rules that are relevant for them
+// Empty field in rule logsource matches any event, same goes for empty field in event logsource
+type logsourceIndex struct {
+	rules                      []*Tree
+	product, category, service logsourceField
+}
+
+type logsourceField struct {
+	// values maps lowercased field value to rules that apply to it, including rules that leave field empty
+	values map[string][]bool
+	// any marks rules that leave field empty, which are the only ones that apply to other values
+	any []bool
+}
+
+// newLogsourceField precomputes rules that apply to each value of logsource field,
+// so that routing an event does not need to allocate anything per field
+func newLogsourceField(rules []*Tree, field func(Logsource) string) logsourceField {
+	f := logsourceField{values: make(map[string][]bool), any: make([]bool, len(rules))}
+	for i, rule := range rules {
+		var ls Logsource
+		if rule.Rule != nil {
+			ls = rule.Rule.Logsource
+		}
+		val := field(ls)
+		if val == "" {
+			f.any[i] = true
+			continue
+		}
+		key := strings.ToLower(val)
+		if f.values[key] == nil {
+			f.values[key] = make([]bool, len(rules))
+		}
+		f.values[key][i] = true
+	}
+	for _, allowed := range f.values {
+		for i, wildcard := range f.any {
+			allowed[i] = allowed[i] || wildcard
+		}
+	}
+	return f
+}
+
+// restrict drops rules that do not apply to event value from candidates
+func (f logsourceField) restrict(val string, candidates []bool) {
+	if val == "" {
+		return
+	}
+	allowed, ok := f.values[strings.ToLower(val)]
+	if !ok {
+		allowed = f.any
+	}
+	for i := range candidates {
+		candidates[i] = candidates[i] && allowed[i]
+	}
+}
+
+func newLogsourceIndex(rules []*Tree) *logsourceIndex {
+	return &logsourceIndex{
+		rules:    rules,
+		product:  newLogsourceField(rules, func(ls Logsource) string { return ls.Product }),
+		category: newLogsourceField(rules, func(ls Logsource) string { return ls.Category }),
+		service:  newLogsourceField(rules, func(ls Logsource) string { return ls.Service }),
+	}
+}
+
+// candidates returns rules that apply to logsource, indexed the same way as rules
+// Nil is returned if index was built for a different rule list, meaning all rules must be evaluated
+func (l *logsourceIndex) candidates(rules []*Tree, ls Logsource) []bool {
+	if l == nil || !sameRules(rules, l.rules) {
+		return nil
+	}
+	out := make([]bool, len(rules))
+	for i := range out {
+		out[i] = true
+	}
+	l.product.restrict(ls.Product, out)
+	l.category.restrict(ls.Category, out)
+	l.service.restrict(ls.Service, out)
+	return out
+}
+
+// Matches reports whether rule written for logsource l applies to event from logsource event
+// Empty fields on either side match any value
+func (l Logsource) Matches(event Logsource) bool {
+	match := func(rule, event string) bool {
+		return rule == "" || event == "" || strings.EqualFold(rule, event)
+	}
+	return match(l.Product, event.Product) &&
+		match(l.Category, event.Category) &&
+		match(l.Service, event.Service)
+}
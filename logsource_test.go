@@ -0,0 +1,102 @@
+package sigma
+
+import (
+	"reflect"
+	"testing"
+)
+
+type logsourceEvent struct {
+	MapEvent
+	ls Logsource
+}
+
+func (e logsourceEvent) Logsource() (Logsource, bool) {
+	return e.ls, e.ls != Logsource{}
+}
+
+func TestRulesetLogsource(t *testing.T) {
+	handle := func(id string, ls Logsource) RuleHandle {
+		return RuleHandle{Rule: Rule{ID: id, Logsource: ls, Detection: Detection{
+			"condition": "selection",
+			"selection": map[interface{}]interface{}{"Image|endswith": "cmd.exe"},
+		}}}
+	}
+	rs := RulesetFromRuleList([]RuleHandle{
+		handle("any", Logsource{}),
+		handle("windows", Logsource{Product: "windows"}),
+		handle("process", Logsource{Product: "windows", Category: "process_creation"}),
+		handle("sysmon", Logsource{Product: "windows", Service: "sysmon"}),
+		handle("linux", Logsource{Product: "linux", Category: "process_creation"}),
+		handle("category", Logsource{Category: "process_creation"}),
+	})
+	event := NewMapEvent(map[string]interface{}{"Image": `C:\Windows\System32\cmd.exe`})
+
+	// routing in EvalAll is opt-in
+	all := logsourceEvent{MapEvent: event, ls: Logsource{Product: "linux"}}
+	if results, _ := rs.EvalAll(all); len(results) != 6 {
+		t.Fatalf("events should not be routed by default, got %d results", len(results))
+	}
+	routing := *rs.Snapshot()
+	routing.routeLogsource = true
+
+	for _, c := range []struct {
+		ls       Logsource
+		expected []string
+	}{
+		{
+			ls:       Logsource{Product: "windows", Category: "process_creation", Service: "sysmon"},
+			expected: []string{"any", "windows", "process", "sysmon", "category"},
+		},
+		{
+			ls:       Logsource{Product: "Windows", Category: "process_creation"},
+			expected: []string{"any", "windows", "process", "sysmon", "category"},
+		},
+		{
+			ls:       Logsource{Product: "windows", Service: "security"},
+			expected: []string{"any", "windows", "process", "category"},
+		},
+		{
+			ls:       Logsource{Product: "linux"},
+			expected: []string{"any", "linux", "category"},
+		},
+		{
+			ls:       Logsource{Category: "network_connection"},
+			expected: []string{"any", "windows", "sysmon"},
+		},
+		{
+			ls:       Logsource{},
+			expected: []string{"any", "windows", "process", "sysmon", "linux", "category"},
+		},
+	} {
+		for i, eval := range []func() (Results, bool){
+			func() (Results, bool) { return rs.EvalLogsource(event, c.ls) },
+			func() (Results, bool) { return routing.EvalAll(logsourceEvent{MapEvent: event, ls: c.ls}) },
+		} {
+			results, _ := eval()
+			var got []string
+			for _, res := range results {
+				got = append(got, res.ID)
+			}
+			if !reflect.DeepEqual(got, c.expected) {
+				t.Fatalf("logsource %+v eval %d returned %v, expected %v", c.ls, i, got, c.expected)
+			}
+			for _, rule := range rs.Rules {
+				matches := rule.Rule.Logsource.Matches(c.ls)
+				var routed bool
+				for _, id := range got {
+					routed = routed || id == rule.Rule.ID
+				}
+				if matches != routed {
+					t.Fatalf("logsource %+v index disagrees with Matches for rule %s", c.ls, rule.Rule.ID)
+				}
+			}
+		}
+	}
+
+	// candidate list is the only allocation, field values are precomputed
+	index := rs.Snapshot().logsources
+	ls := Logsource{Product: "windows", Category: "process_creation", Service: "sysmon"}
+	if allocs := testing.AllocsPerRun(100, func() { index.candidates(rs.Snapshot().Rules, ls) }); allocs > 1 {
+		t.Fatalf("routing allocated %.0f times per event", allocs)
+	}
+}
@@ -0,0 +1,351 @@
+package sigma
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// valueTransform implements a value modifier that is applied to rule pattern when rule is parsed
+// Input and output are sigma patterns, i.e. wildcards and escapes keep their meaning
+// A single value may be expanded into multiple alternatives, for example by base64offset
+type valueTransform struct {
+	Name string
+	// Encoding transforms produce byte sequences that are matched as is
+	Encoding bool
+	fn       func(string) ([]string, error)
+}
+
+// newValueTransform returns transform for modifier name, false if name is not a transform
+func newValueTransform(name string) (valueTransform, bool) {
+	switch name {
+	case "base64":
+		return valueTransform{Name: name, Encoding: true, fn: transformBase64}, true
+	case "base64offset":
+		return valueTransform{Name: name, Encoding: true, fn: transformBase64Offset}, true
+	case "utf16le", "wide":
+		return valueTransform{Name: name, Encoding: true, fn: transformUTF16(false, false)}, true
+	case "utf16be":
+		return valueTransform{Name: name, Encoding: true, fn: transformUTF16(true, false)}, true
+	case "utf16":
+		return valueTransform{Name: name, Encoding: true, fn: transformUTF16(false, true)}, true
+	case "windash":
+		return valueTransform{Name: name, fn: transformWindash}, true
+	}
+	return valueTransform{}, false
+}
+
+// regexFlagTransform prefixes regular expression with inline flags from re sub-modifiers
+// i is case insensitive, m is multiline and s lets . match newlines, same as in go regexp syntax
+func regexFlagTransform(flags string) valueTransform {
+	return valueTransform{Name: "re|" + flags, fn: func(in string) ([]string, error) {
+		return []string{"(?" + flags + ")" + in}, nil
+	}}
+}
+
+// applyValueTransforms applies transforms to each value in the order given
+// Returns a group of alternatives for every original value
+func applyValueTransforms(values []string, transforms []valueTransform) ([][]string, error) {
+	groups := make([][]string, 0, len(values))
+	for _, v := range values {
+		group := []string{v}
+		for _, t := range transforms {
+			next := make([]string, 0, len(group))
+			for _, item := range group {
+				out, err := t.fn(item)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, out...)
+			}
+			group = next
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// newTransformedStringMatcher applies value transforms to patterns before building a string matcher
+// With all modifier, every original value must match through at least one of its alternatives
+func newTransformedStringMatcher(
+	mod TextPatternModifier,
+	lower, all, noCollapseWS bool,
+	transforms []valueTransform,
+	patterns ...string,
+) (StringMatcher, error) {
+	if len(transforms) == 0 {
+		return NewStringMatcher(mod, lower, all, noCollapseWS, patterns...)
+	}
+	var encoded bool
+	for _, t := range transforms {
+		if t.Encoding {
+			encoded = true
+		}
+	}
+	if encoded && mod == TextPatternRegex {
+		return nil, fmt.Errorf("regular expressions can not be combined with encoding modifiers")
+	}
+	build := func(patterns []string) (StringMatcher, error) {
+		if encoded {
+			return newEncodedMatcher(mod, patterns...)
+		}
+		return NewStringMatcher(mod, lower, false, noCollapseWS, patterns...)
+	}
+	groups, err := applyValueTransforms(patterns, transforms)
+	if err != nil {
+		return nil, err
+	}
+	if !all {
+		flat := make([]string, 0, len(groups))
+		for _, g := range groups {
+			flat = append(flat, g...)
+		}
+		return build(flat)
+	}
+	conj := make(StringMatchersConj, 0, len(groups))
+	for _, g := range groups {
+		m, err := build(g)
+		if err != nil {
+			return nil, err
+		}
+		conj = append(conj, m)
+	}
+	if len(conj) == 1 {
+		return conj[0], nil
+	}
+	return conj.Optimize(), nil
+}
+
+// newEncodedMatcher builds matchers for values produced by encoding modifiers
+// Encoded values are often not valid UTF-8, nor would they survive lowercasing
+// so they are matched byte by byte and case sensitively
+func newEncodedMatcher(mod TextPatternModifier, patterns ...string) (StringMatcher, error) {
+	matcher := make(StringMatchers, 0, len(patterns))
+	for _, p := range patterns {
+		tokens := parseBytePattern(p)
+		switch mod {
+		case TextPatternContains, TextPatternKeyword:
+			tokens = append(append([]int16{byteWildcard}, tokens...), byteWildcard)
+		case TextPatternPrefix:
+			tokens = append(tokens, byteWildcard)
+		case TextPatternSuffix:
+			tokens = append([]int16{byteWildcard}, tokens...)
+		}
+		matcher = append(matcher, BytePattern{Tokens: tokens})
+	}
+	if len(matcher) == 1 {
+		return matcher[0], nil
+	}
+	return matcher, nil
+}
+
+const (
+	byteWildcard int16 = -1 - iota
+	byteSingle
+)
+
+// parseBytePattern converts sigma pattern to a list of byte values and wildcards
+// escape character makes the following wildcard or escape character literal
+func parseBytePattern(in string) []int16 {
+	tokens := make([]int16, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; c {
+		case sigmaSpecialEscape:
+			if i+1 < len(in) && isSigmaSpecial(in[i+1]) {
+				i++
+			}
+			tokens = append(tokens, int16(in[i]))
+		case sigmaSpecialWildcard:
+			tokens = append(tokens, byteWildcard)
+		case sigmaSpecialSingle:
+			tokens = append(tokens, byteSingle)
+		default:
+			tokens = append(tokens, int16(c))
+		}
+	}
+	return tokens
+}
+
+func isSigmaSpecial(c byte) bool {
+	return c == sigmaSpecialEscape || c == sigmaSpecialWildcard || c == sigmaSpecialSingle
+}
+
+// BytePattern matches byte sequences with sigma wildcards
+// Each token is a byte value, or a negative constant for * and ? wildcards
+// ? matches a single byte
+type BytePattern struct {
+	Tokens []int16
+}
+
+// StringMatch implements StringMatcher
+func (b BytePattern) StringMatch(msg string) bool {
+	var pi, si int
+	star, restart := -1, 0
+	for si < len(msg) {
+		if pi < len(b.Tokens) && (b.Tokens[pi] == byteSingle || b.Tokens[pi] == int16(msg[si])) {
+			pi++
+			si++
+			continue
+		}
+		if pi < len(b.Tokens) && b.Tokens[pi] == byteWildcard {
+			star, restart = pi, si
+			pi++
+			continue
+		}
+		if star >= 0 {
+			restart++
+			pi, si = star+1, restart
+			continue
+		}
+		return false
+	}
+	for pi < len(b.Tokens) && b.Tokens[pi] == byteWildcard {
+		pi++
+	}
+	return pi == len(b.Tokens)
+}
+
+// sigmaChar is a single element of a parsed sigma pattern
+// wildcard is set for unescaped * and ?, r holds the wildcard or literal character
+// raw holds original bytes of literal, as output of a previous encoding may not be valid UTF-8
+type sigmaChar struct {
+	r        rune
+	raw      string
+	wildcard bool
+}
+
+func parseSigmaChars(in string) []sigmaChar {
+	out := make([]sigmaChar, 0, len(in))
+	for i := 0; i < len(in); {
+		r, width := utf8.DecodeRuneInString(in[i:])
+		if r == utf8.RuneError && width == 1 {
+			// keep invalid bytes as they are
+			r = rune(in[i])
+		}
+		raw := in[i : i+width]
+		i += width
+		switch {
+		case r == rune(sigmaSpecialEscape) && i < len(in) && isSigmaSpecial(in[i]):
+			out = append(out, sigmaChar{r: rune(in[i]), raw: in[i : i+1]})
+			i++
+		case r == rune(sigmaSpecialWildcard) || r == rune(sigmaSpecialSingle):
+			out = append(out, sigmaChar{r: r, raw: raw, wildcard: true})
+		default:
+			out = append(out, sigmaChar{r: r, raw: raw})
+		}
+	}
+	return out
+}
+
+// escapeSigmaByte writes a byte of encoded output, escaping it if it collides with sigma special characters
+func escapeSigmaByte(b *strings.Builder, c byte) {
+	if isSigmaSpecial(c) {
+		b.WriteByte(sigmaSpecialEscape)
+	}
+	b.WriteByte(c)
+}
+
+func unescapeSigma(in string) (string, error) {
+	var b strings.Builder
+	for _, c := range parseSigmaChars(in) {
+		if c.wildcard {
+			return "", fmt.Errorf("wildcards are not supported with base64 modifiers: %s", in)
+		}
+		b.WriteString(c.raw)
+	}
+	return b.String(), nil
+}
+
+func transformBase64(in string) ([]string, error) {
+	raw, err := unescapeSigma(in)
+	if err != nil {
+		return nil, err
+	}
+	return []string{base64.StdEncoding.EncodeToString([]byte(raw))}, nil
+}
+
+// transformBase64Offset produces three variants of encoded value, one for each
+// possible position of the value within a larger base64 encoded string
+// Leading and trailing characters that depend on surrounding data are cut off
+func transformBase64Offset(in string) ([]string, error) {
+	raw, err := unescapeSigma(in)
+	if err != nil {
+		return nil, err
+	}
+	start := []int{0, 2, 3}
+	end := []int{0, 3, 2}
+	out := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		enc := base64.StdEncoding.EncodeToString([]byte(strings.Repeat(" ", i) + raw))
+		out = append(out, enc[start[i]:len(enc)-end[(len(raw)+i)%3]])
+	}
+	return out, nil
+}
+
+// transformUTF16 encodes value as UTF-16, optionally big endian or prefixed with byte order mark
+// Wildcards are kept, ? is doubled to cover both bytes of a UTF-16 code unit
+func transformUTF16(bigEndian, bom bool) func(string) ([]string, error) {
+	return func(in string) ([]string, error) {
+		var b strings.Builder
+		if bom {
+			b.WriteString("\xff\xfe")
+		}
+		for _, c := range parseSigmaChars(in) {
+			if c.wildcard {
+				b.WriteRune(c.r)
+				if c.r == rune(sigmaSpecialSingle) {
+					b.WriteRune(c.r)
+				}
+				continue
+			}
+			for _, u := range utf16.Encode([]rune{c.r}) {
+				if bigEndian {
+					escapeSigmaByte(&b, byte(u>>8))
+					escapeSigmaByte(&b, byte(u))
+				} else {
+					escapeSigmaByte(&b, byte(u))
+					escapeSigmaByte(&b, byte(u>>8))
+				}
+			}
+		}
+		return []string{b.String()}, nil
+	}
+}
+
+// windashChars are the characters accepted as command-line flag prefix by many windows programs
+var windashChars = []string{"-", "/", "\u2013", "\u2014", "\u2015"}
+
+// transformWindash expands every flag prefix in value into all windash variants
+// Like pySigma, a dash or slash is a flag prefix when it is not preceded by a word character
+// and is followed by one, e.g. in " -enc", "*-enc" or `"/c`
+func transformWindash(in string) ([]string, error) {
+	out := []string{""}
+	runes := []rune(in)
+	for i, r := range runes {
+		prefix := (r == '-' || r == '/') &&
+			(i == 0 || !isWordRune(runes[i-1])) &&
+			i+1 < len(runes) && isWordRune(runes[i+1])
+		if prefix {
+			next := make([]string, 0, len(out)*len(windashChars))
+			for _, o := range out {
+				for _, c := range windashChars {
+					next = append(next, o+c)
+				}
+			}
+			out = next
+		} else {
+			for i := range out {
+				out[i] += string(r)
+			}
+		}
+	}
+	return out, nil
+}
+
+// isWordRune reports whether r is matched by \w in python regular expressions
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,92 @@
+package sigma
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValueTransforms(t *testing.T) {
+	cases := []struct {
+		Modifiers []string
+		Value     string
+		Expected  []string
+	}{
+		{Modifiers: []string{"base64"}, Value: "foobar", Expected: []string{"Zm9vYmFy"}},
+		{Modifiers: []string{"base64offset"}, Value: "foobar", Expected: []string{"Zm9vYmFy", "Zvb2Jhc", "mb29iYX"}},
+		{Modifiers: []string{"base64offset"}, Value: "foob", Expected: []string{"Zm9vY", "Zvb2", "mb29i"}},
+		{Modifiers: []string{"utf16le"}, Value: "ab", Expected: []string{"a\x00b\x00"}},
+		{Modifiers: []string{"wide"}, Value: "a*?", Expected: []string{"a\x00*??"}},
+		{Modifiers: []string{"utf16be"}, Value: `C:\*`, Expected: []string{"\x00C\x00:\x00\\*"}},
+		{Modifiers: []string{"utf16be"}, Value: "ab", Expected: []string{"\x00a\x00b"}},
+		{Modifiers: []string{"utf16"}, Value: "ab", Expected: []string{"\xff\xfea\x00b\x00"}},
+		{Modifiers: []string{"wide", "base64"}, Value: "ab", Expected: []string{"YQBiAA=="}},
+		{Modifiers: []string{"windash"}, Value: "-a", Expected: []string{"-a", "/a", "\u2013a", "\u2014a", "\u2015a"}},
+		{Modifiers: []string{"windash"}, Value: "x-y /z", Expected: []string{"x-y -z", "x-y /z", "x-y \u2013z", "x-y \u2014z", "x-y \u2015z"}},
+		{Modifiers: []string{"windash"}, Value: "*-enc", Expected: []string{"*-enc", "*/enc", "*\u2013enc", "*\u2014enc", "*\u2015enc"}},
+		{Modifiers: []string{"windash"}, Value: `"/c"`, Expected: []string{`"-c"`, `"/c"`, "\"\u2013c\"", "\"\u2014c\"", "\"\u2015c\""}},
+		{Modifiers: []string{"windash"}, Value: "a - b --c", Expected: []string{"a - b --c", "a - b -/c", "a - b -\u2013c", "a - b -\u2014c", "a - b -\u2015c"}},
+	}
+	for i, c := range cases {
+		transforms := make([]valueTransform, 0)
+		for _, mod := range c.Modifiers {
+			tr, ok := newValueTransform(mod)
+			if !ok {
+				t.Fatalf("case %d: unknown transform %s", i, mod)
+			}
+			transforms = append(transforms, tr)
+		}
+		groups, err := applyValueTransforms([]string{c.Value}, transforms)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(groups[0], c.Expected) {
+			t.Fatalf("case %d: expected %q got %q", i, c.Expected, groups[0])
+		}
+	}
+	tr, _ := newValueTransform("base64")
+	if _, err := applyValueTransforms([]string{"foo*"}, []valueTransform{tr}); err == nil {
+		t.Fatal("wildcards should not be allowed with base64")
+	}
+	for key, val := range map[string]interface{}{
+		"Field|base64|exists":    true,
+		"Field|windash|fieldref": "Other",
+		"Field|utf16le|cidr":     "10.0.0.0/8",
+		"Field|base64offset|gte": 4,
+	} {
+		if _, err := newSelectionFromMap(map[string]interface{}{key: val}, false, nil); err == nil ||
+			!strings.Contains(err.Error(), "can not be combined with value modifiers") {
+			t.Fatalf("selection key %s should fail with value modifiers, got %v", key, err)
+		}
+	}
+}
+
+func TestBytePattern(t *testing.T) {
+	cases := []struct {
+		Mod     TextPatternModifier
+		Pattern string
+		Pos     []string
+		Neg     []string
+	}{
+		{Mod: TextPatternContains, Pattern: "a\x00*c\x00", Pos: []string{"\x00a\x00b\x00c\x00", "a\x00c\x00"}, Neg: []string{"a\x00b\x00"}},
+		{Mod: TextPatternNone, Pattern: "a?c", Pos: []string{"abc"}, Neg: []string{"ac", "abbc"}},
+		{Mod: TextPatternPrefix, Pattern: `\*x`, Pos: []string{"*xyz"}, Neg: []string{"axyz"}},
+		{Mod: TextPatternSuffix, Pattern: "\xff\xfe", Pos: []string{"abc\xff\xfe"}, Neg: []string{"\xff\xfeabc"}},
+	}
+	for i, c := range cases {
+		m, err := newEncodedMatcher(c.Mod, c.Pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pos := range c.Pos {
+			if !m.StringMatch(pos) {
+				t.Fatalf("case %d: %q should match %q", i, c.Pattern, pos)
+			}
+		}
+		for _, neg := range c.Neg {
+			if m.StringMatch(neg) {
+				t.Fatalf("case %d: %q should not match %q", i, c.Pattern, neg)
+			}
+		}
+	}
+}
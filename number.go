@@ -0,0 +1,189 @@
+package sigma
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// NumberKind indicates which field of Number holds the value
+type NumberKind int
+
+const (
+	NumberInt NumberKind = iota
+	NumberUint
+	NumberFloat
+)
+
+// Number is a numeric value from a rule or an event
+// Value is kept in original type, so large integers and floats are compared without truncation
+type Number struct {
+	Kind  NumberKind
+	Int   int64
+	Uint  uint64
+	Float float64
+}
+
+func (n Number) String() string {
+	switch n.Kind {
+	case NumberUint:
+		return strconv.FormatUint(n.Uint, 10)
+	case NumberFloat:
+		return strconv.FormatFloat(n.Float, 'f', -1, 64)
+	default:
+		return strconv.FormatInt(n.Int, 10)
+	}
+}
+
+// NewNumber converts numeric types, json.Number and numeric strings to Number
+// Second return value is false if value is not numeric
+func NewNumber(val interface{}) (Number, bool) {
+	switch v := val.(type) {
+	case int:
+		return Number{Kind: NumberInt, Int: int64(v)}, true
+	case int64:
+		return Number{Kind: NumberInt, Int: v}, true
+	case int32:
+		return Number{Kind: NumberInt, Int: int64(v)}, true
+	case int16:
+		return Number{Kind: NumberInt, Int: int64(v)}, true
+	case int8:
+		return Number{Kind: NumberInt, Int: int64(v)}, true
+	case uint:
+		return Number{Kind: NumberUint, Uint: uint64(v)}, true
+	case uint64:
+		return Number{Kind: NumberUint, Uint: v}, true
+	case uint32:
+		return Number{Kind: NumberUint, Uint: uint64(v)}, true
+	case uint16:
+		return Number{Kind: NumberUint, Uint: uint64(v)}, true
+	case uint8:
+		return Number{Kind: NumberUint, Uint: uint64(v)}, true
+	case float64:
+		return Number{Kind: NumberFloat, Float: v}, true
+	case float32:
+		return Number{Kind: NumberFloat, Float: float64(v)}, true
+	case json.Number:
+		return ParseNumber(string(v))
+	case string:
+		return ParseNumber(v)
+	}
+	return Number{}, false
+}
+
+// ParseNumber parses numeric strings, surrounding whitespace is ignored
+// Integers are preferred over floats, so that no precision is lost
+func ParseNumber(in string) (Number, bool) {
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return Number{}, false
+	}
+	if i, err := strconv.ParseInt(in, 10, 64); err == nil {
+		return Number{Kind: NumberInt, Int: i}, true
+	}
+	if u, err := strconv.ParseUint(in, 10, 64); err == nil {
+		return Number{Kind: NumberUint, Uint: u}, true
+	}
+	// ParseFloat also accepts inf and nan, which are words rather than numbers in event data
+	if f, err := strconv.ParseFloat(in, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return Number{Kind: NumberFloat, Float: f}, true
+	}
+	return Number{}, false
+}
+
+// Compare returns -1, 0 or 1 if n is less than, equal to or greater than o
+// Second return value is false if numbers are not comparable, i.e. one of them is NaN
+func (n Number) Compare(o Number) (int, bool) {
+	switch {
+	case n.Kind == NumberFloat && o.Kind == NumberFloat:
+		if math.IsNaN(n.Float) || math.IsNaN(o.Float) {
+			return 0, false
+		}
+		return cmpFloat(n.Float, o.Float), true
+	case n.Kind == NumberFloat:
+		c, ok := o.Compare(n)
+		return -c, ok
+	case o.Kind == NumberFloat:
+		if math.IsNaN(o.Float) {
+			return 0, false
+		}
+		if n.Kind == NumberUint {
+			return cmpUintFloat(n.Uint, o.Float), true
+		}
+		return cmpIntFloat(n.Int, o.Float), true
+	case n.Kind == NumberInt && o.Kind == NumberInt:
+		return cmpInt(n.Int, o.Int), true
+	case n.Kind == NumberUint && o.Kind == NumberUint:
+		return cmpUint(n.Uint, o.Uint), true
+	case n.Kind == NumberInt:
+		if n.Int < 0 {
+			return -1, true
+		}
+		return cmpUint(uint64(n.Int), o.Uint), true
+	default:
+		if o.Int < 0 {
+			return 1, true
+		}
+		return cmpUint(n.Uint, uint64(o.Int)), true
+	}
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// cmpIntFloat compares integer part first, so integers beyond float64 precision stay exact
+func cmpIntFloat(i int64, f float64) int {
+	if f >= math.MaxInt64 {
+		return -1
+	}
+	if f < math.MinInt64 {
+		return 1
+	}
+	t := math.Trunc(f)
+	if c := cmpInt(i, int64(t)); c != 0 {
+		return c
+	}
+	return cmpFloat(t, f)
+}
+
+func cmpUintFloat(u uint64, f float64) int {
+	if f >= math.MaxUint64 {
+		return -1
+	}
+	if f < 0 {
+		return 1
+	}
+	t := math.Trunc(f)
+	if c := cmpUint(u, uint64(t)); c != 0 {
+		return c
+	}
+	return cmpFloat(t, f)
+}
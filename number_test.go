@@ -0,0 +1,49 @@
+package sigma
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestNumberCompare(t *testing.T) {
+	cases := []struct {
+		A, B interface{}
+		Cmp  int
+		Ok   bool
+	}{
+		{A: 1, B: 1, Cmp: 0, Ok: true},
+		{A: 1, B: 1.5, Cmp: -1, Ok: true},
+		{A: 2.0, B: 2, Cmp: 0, Ok: true},
+		{A: -1, B: uint64(1), Cmp: -1, Ok: true},
+		{A: uint64(math.MaxUint64), B: int64(math.MaxInt64), Cmp: 1, Ok: true},
+		// not representable as float64, would be equal after conversion
+		{A: int64(1<<53 + 1), B: float64(1 << 53), Cmp: 1, Ok: true},
+		{A: uint64(1<<63 + 1), B: float64(1 << 63), Cmp: 1, Ok: true},
+		{A: json.Number("4624"), B: 4624, Cmp: 0, Ok: true},
+		{A: " 10 ", B: 9, Cmp: 1, Ok: true},
+		{A: "-1.25", B: -1, Cmp: -1, Ok: true},
+		{A: math.NaN(), B: 1, Ok: false},
+	}
+	for i, c := range cases {
+		a, ok := NewNumber(c.A)
+		if !ok {
+			t.Fatalf("case %d: %v should be numeric", i, c.A)
+		}
+		b, ok := NewNumber(c.B)
+		if !ok {
+			t.Fatalf("case %d: %v should be numeric", i, c.B)
+		}
+		cmp, ok := a.Compare(b)
+		if ok != c.Ok || (ok && cmp != c.Cmp) {
+			t.Fatalf("case %d: %s compared to %s expected %d %t got %d %t", i, a, b, c.Cmp, c.Ok, cmp, ok)
+		}
+	}
+	for _, val := range []interface{}{
+		"", "abc", "1.2.3", true, nil, "inf", "+Inf", "-infinity", "nan", "NaN", json.Number("Infinity"),
+	} {
+		if _, ok := NewNumber(val); ok {
+			t.Fatalf("%v should not be numeric", val)
+		}
+	}
+}
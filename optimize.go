@@ -0,0 +1,328 @@
+package sigma
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// estimated evaluation cost of patterns, follows the same order as optimizeStringMatchers
+const (
+	costLiteral = 1
+	costGlob    = 4
+	costRegex   = 16
+	// nodes that are not known to optimizer, e.g. custom matchers
+	costUnknown = 32
+)
+
+// Optimize rewrites rule tree into an equivalent tree that is cheaper to evaluate
+func (t *Tree) Optimize() {
+	t.Root = Optimize(t.Root)
+}
+
+// Optimize returns a branch that gives the same match and applicable results as b for every event
+// Nested conjunctions and disjunctions are flattened, duplicate children are removed,
+// double negations are dropped and children of disjunctions are ordered by estimated cost.
+//
+// Conjunction returns the applicable flag of the first child that did not match, so its
+// children are only reordered or negated via De Morgan where all of them are always applicable.
+// Selections that read event fields are not applicable to events without those fields,
+// so in practice conjunctions are only reordered around exists-only selections.
+func Optimize(b Branch) Branch {
+	switch n := b.(type) {
+	case NodeSimpleAnd:
+		return optimizeAnd(n)
+	case NodeAnd:
+		return optimizeAnd([]Branch{n.L, n.R})
+	case *NodeAnd:
+		return optimizeAnd([]Branch{n.L, n.R})
+	case NodeSimpleOr:
+		return optimizeOr(n)
+	case NodeOr:
+		return optimizeOr([]Branch{n.L, n.R})
+	case *NodeOr:
+		return optimizeOr([]Branch{n.L, n.R})
+	case NodeNot:
+		return optimizeNot(n.B)
+	case *NodeNot:
+		return optimizeNot(n.B)
+	}
+	return b
+}
+
+func optimizeAnd(children []Branch) Branch {
+	out := make([]Branch, 0, len(children))
+	for _, c := range children {
+		c = Optimize(c)
+		// conjunction is associative, nested nodes can be spliced in place
+		if nested, ok := conjunctionChildren(c); ok {
+			out = append(out, nested...)
+			continue
+		}
+		out = append(out, c)
+	}
+	// later duplicate is only evaluated when the first one matched, so it would match as well
+	out = dedupBranches(out)
+	// only children that are always applicable can swap places, as otherwise
+	// the first mismatching child that defines applicable flag could change
+	for start := 0; start < len(out); {
+		if !alwaysApplicable(out[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(out) && alwaysApplicable(out[end]) {
+			end++
+		}
+		run := out[start:end]
+		sort.SliceStable(run, func(i, j int) bool {
+			ci, cj := estimateCost(run[i]), estimateCost(run[j])
+			if ci != cj {
+				return ci < cj
+			}
+			// more conditions are less likely to match, so conjunction can stop earlier
+			return countConditions(run[i]) > countConditions(run[j])
+		})
+		start = end
+	}
+	return NodeSimpleAnd(out).Reduce()
+}
+
+func optimizeOr(children []Branch) Branch {
+	out := make([]Branch, 0, len(children))
+	for _, c := range children {
+		c = Optimize(c)
+		if nested, ok := disjunctionChildren(c); ok {
+			out = append(out, nested...)
+			continue
+		}
+		out = append(out, c)
+	}
+	out = dedupBranches(out)
+	// disjunction result does not depend on order of children
+	sort.SliceStable(out, func(i, j int) bool {
+		ci, cj := estimateCost(out[i]), estimateCost(out[j])
+		if ci != cj {
+			return ci < cj
+		}
+		// fewer conditions are more likely to match, so disjunction can stop earlier
+		return countConditions(out[i]) < countConditions(out[j])
+	})
+	return NodeSimpleOr(out).Reduce()
+}
+
+func optimizeNot(child Branch) Branch {
+	c := Optimize(child)
+	if inner, ok := negatedBranch(c); ok {
+		return inner
+	}
+	// De Morgan only holds for children that can not be inapplicable, otherwise
+	// not (a and b) would be applicable when a is not but b is
+	if and, ok := conjunctionChildren(c); ok && pushNegation(and) {
+		return optimizeOr(negateBranches(and))
+	}
+	if or, ok := disjunctionChildren(c); ok && pushNegation(or) {
+		return optimizeAnd(negateBranches(or))
+	}
+	return &NodeNot{B: c}
+}
+
+// pushNegation reports whether negation can be moved to children without changing results
+// and whether that would reduce the number of negations
+func pushNegation(children []Branch) bool {
+	var negated int
+	for _, c := range children {
+		if !alwaysApplicable(c) {
+			return false
+		}
+		if _, ok := negatedBranch(c); ok {
+			negated++
+		}
+	}
+	return 2*negated >= len(children)
+}
+
+func negateBranches(children []Branch) []Branch {
+	out := make([]Branch, 0, len(children))
+	for _, c := range children {
+		if inner, ok := negatedBranch(c); ok {
+			out = append(out, inner)
+			continue
+		}
+		out = append(out, &NodeNot{B: c})
+	}
+	return out
+}
+
+func conjunctionChildren(b Branch) ([]Branch, bool) {
+	switch n := b.(type) {
+	case NodeSimpleAnd:
+		return n, true
+	case NodeAnd:
+		return []Branch{n.L, n.R}, true
+	case *NodeAnd:
+		return []Branch{n.L, n.R}, true
+	}
+	return nil, false
+}
+
+func disjunctionChildren(b Branch) ([]Branch, bool) {
+	switch n := b.(type) {
+	case NodeSimpleOr:
+		return n, true
+	case NodeOr:
+		return []Branch{n.L, n.R}, true
+	case *NodeOr:
+		return []Branch{n.L, n.R}, true
+	}
+	return nil, false
+}
+
+func negatedBranch(b Branch) (Branch, bool) {
+	switch n := b.(type) {
+	case NodeNot:
+		return n.B, true
+	case *NodeNot:
+		return n.B, true
+	}
+	return nil, false
+}
+
+// alwaysApplicable reports whether branch can never return not applicable result
+// Only selections that check nothing but field presence are, as missing field is a valid answer for them
+func alwaysApplicable(b Branch) bool {
+	if children, ok := conjunctionChildren(b); ok {
+		for _, c := range children {
+			if !alwaysApplicable(c) {
+				return false
+			}
+		}
+		return true
+	}
+	if children, ok := disjunctionChildren(b); ok {
+		for _, c := range children {
+			if alwaysApplicable(c) {
+				return true
+			}
+		}
+		return false
+	}
+	if inner, ok := negatedBranch(b); ok {
+		return alwaysApplicable(inner)
+	}
+	switch s := indirectNode(b).(type) {
+	case Selection:
+		return len(s.N)+len(s.S)+len(s.F)+len(s.B)+len(s.I) == 0
+	}
+	return false
+}
+
+// dedupBranches removes structurally equal branches, first occurrence is kept
+func dedupBranches(branches []Branch) []Branch {
+	seen := make(map[string]bool, len(branches))
+	out := branches[:0]
+	for _, b := range branches {
+		key, ok := fingerprint(b)
+		if ok && seen[key] {
+			continue
+		}
+		if ok {
+			seen[key] = true
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// fingerprint returns a key that is equal for structurally equal branches
+// False is returned if branch holds nodes that can not be compared by their description
+func fingerprint(b Branch) (string, bool) {
+	known := true
+	walk(b, visitorFunc(func(n Node) bool {
+		known = known && describable(n)
+		return known
+	}))
+	if !known {
+		return "", false
+	}
+	out, err := json.Marshal(Describe(b))
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+// describable reports whether NodeInfo holds everything that defines behavior of node
+func describable(n Node) bool {
+	switch v := indirectNode(n).(type) {
+	case NodeSimpleAnd, NodeSimpleOr, NodeAnd, NodeOr, NodeNot, Keyword, Selection,
+		SelectionExistsItem, SelectionNumItem, SelectionStringItem, SelectionNetItem,
+		SelectionBoolItem, SelectionFieldRefItem, LowercasePattern, StringMatchers, StringMatchersConj,
+		ContentPattern, PrefixPattern, SuffixPattern, SimplePattern, BytePattern,
+		NumMatchers, NumPattern, PrefixTrie:
+		// conjunction and disjunction of patterns differ by Kind, so both can be keyed by description
+		return true
+	case GlobPattern:
+		return v.Pattern != ""
+	case RegexPattern:
+		return v.Re != nil
+	}
+	return false
+}
+
+// estimateCost returns relative cost of evaluating node for a negative match
+func estimateCost(n Node) int {
+	var own int
+	switch v := indirectNode(n).(type) {
+	case ContentPattern, PrefixPattern, SuffixPattern, SimplePattern, NumPattern, PrefixTrie,
+		SelectionExistsItem, SelectionBoolItem:
+		own = costLiteral
+	case GlobPattern, BytePattern:
+		own = costGlob
+	case RegexPattern:
+		own = costRegex
+	case SelectionFieldRefItem:
+		own = costLiteral * (1 + len(v.Refs))
+	case SelectionNumItem, SelectionStringItem, SelectionNetItem, LowercasePattern:
+		// field lookup or lowercasing of message
+		own = costLiteral
+	case NodeSimpleAnd, NodeSimpleOr, NodeAnd, NodeOr, NodeNot, Selection,
+		StringMatchers, StringMatchersConj, NumMatchers:
+	case Keyword:
+		// keywords are matched against multiple fields of event
+		own = costLiteral
+		for _, c := range v.Children() {
+			own += 2 * estimateCost(c)
+		}
+		return own
+	default:
+		return costUnknown
+	}
+	for _, c := range n.Children() {
+		own += estimateCost(c)
+	}
+	return own
+}
+
+// countConditions returns number of selection items and keywords, used as estimate of selectivity
+func countConditions(n Node) int {
+	var count int
+	walk(n, visitorFunc(func(n Node) bool {
+		switch indirectNode(n).(type) {
+		case Keyword, SelectionExistsItem, SelectionNumItem, SelectionStringItem, SelectionNetItem,
+			SelectionBoolItem, SelectionFieldRefItem:
+			count++
+			return false
+		}
+		return true
+	}))
+	return count
+}
+
+// visitorFunc is a Visitor that only needs Enter hook
+type visitorFunc func(Node) bool
+
+// Enter implements Visitor
+func (f visitorFunc) Enter(n Node) bool { return f(n) }
+
+// Leave implements Visitor
+func (f visitorFunc) Leave(Node) {}
@@ -0,0 +1,117 @@
+package sigma
+
+import (
+	"testing"
+)
+
+func optimizeTestTree(t *testing.T, condition string) *Tree {
+	t.Helper()
+	tree, err := NewTree(RuleHandle{Rule: Rule{Detection: Detection{
+		"condition": condition,
+		"sel1":      map[interface{}]interface{}{"A": "foo"},
+		"sel2":      map[interface{}]interface{}{"B|contains": "bar"},
+		"sel3":      map[interface{}]interface{}{"C|re": "^x"},
+		"sel4":      map[interface{}]interface{}{"D|exists": true},
+		"sel5":      map[interface{}]interface{}{"E|exists": false},
+		"sel6":      map[interface{}]interface{}{"D|exists": true, "E|exists": true},
+		"sel7":      map[interface{}]interface{}{"B|contains|all": []interface{}{"x", "bar"}},
+	}}})
+	if err != nil {
+		t.Fatalf("condition %s failed to parse: %s", condition, err)
+	}
+	return tree
+}
+
+var optimizeConditions = []string{
+	"sel1 and (sel2 and sel3)",
+	"sel3 or sel1 or sel3",
+	"(sel3 or sel2) or (sel1 or sel3)",
+	"sel1 and sel2 and sel1",
+	"sel2 and sel1 or sel1 and sel2",
+	"not not sel1",
+	"not (sel1 and sel2)",
+	"not (sel1 or sel3)",
+	"not (not sel4 and not sel5)",
+	"not (not sel4 or not sel5) and sel1",
+	"not (not sel1 and not sel4)",
+	"sel6 and sel4 and sel5",
+	"sel6 and sel3 and sel4",
+	"sel4 and sel1 and sel5 and not sel4",
+	"1 of sel* and all of sel*",
+	"not (sel6 or sel4) or (sel3 and not sel2)",
+	"sel7 and sel2 or sel2 and sel7",
+}
+
+func TestOptimizeStructure(t *testing.T) {
+	for _, c := range []struct {
+		condition, expected string
+	}{
+		{condition: "sel1 and (sel2 and sel3)", expected: "sel1 and sel2 and sel3"},
+		{condition: "sel3 or sel1 or sel3", expected: "sel1 or sel3"},
+		{condition: "(sel3 or sel2) or (sel1 or sel3)", expected: "sel1 or sel2 or sel3"},
+		{condition: "sel1 and sel2 and sel1", expected: "sel1 and sel2"},
+		{condition: "sel7 or sel1 or sel7", expected: "sel1 or sel7"},
+		{condition: "not not sel1", expected: "sel1"},
+		{condition: "not (not sel4 and not sel5)", expected: "sel4 or sel5"},
+		// inapplicable children keep negation outside
+		{condition: "not (not sel1 and not sel4)", expected: "not (not sel1 and not sel4)"},
+		// only always applicable children are reordered in conjunction
+		{condition: "sel6 and sel4 and sel5", expected: "sel4 and sel5 and sel6"},
+		{condition: "sel6 and sel3 and sel4", expected: "sel6 and sel3 and sel4"},
+	} {
+		optimized := optimizeTestTree(t, c.condition)
+		optimized.Optimize()
+		expected := optimizeTestTree(t, c.expected)
+		if optimized.String() != expected.String() {
+			t.Fatalf("condition %s optimized to\n%s\nexpected\n%s", c.condition, optimized, expected)
+		}
+	}
+}
+
+func TestOptimizeEquivalence(t *testing.T) {
+	// every field is either missing, matching or not matching
+	values := map[string][]interface{}{
+		"A": {nil, "foo", "baz"},
+		"B": {nil, "xbarx", "baz"},
+		"C": {nil, "xyz", "yyz"},
+		"D": {nil, "1", "2"},
+		"E": {nil, "1", "2"},
+	}
+	fields := []string{"A", "B", "C", "D", "E"}
+	var events []MapEvent
+	var gen func(i int, data map[string]interface{})
+	gen = func(i int, data map[string]interface{}) {
+		if i == len(fields) {
+			cp := make(map[string]interface{}, len(data))
+			for k, v := range data {
+				cp[k] = v
+			}
+			events = append(events, NewMapEvent(cp))
+			return
+		}
+		for _, v := range values[fields[i]] {
+			if v == nil {
+				delete(data, fields[i])
+			} else {
+				data[fields[i]] = v
+			}
+			gen(i+1, data)
+		}
+		delete(data, fields[i])
+	}
+	gen(0, map[string]interface{}{})
+
+	for _, condition := range optimizeConditions {
+		original := optimizeTestTree(t, condition)
+		// optimizer must not modify original tree
+		optimized := &Tree{Root: Optimize(original.Root)}
+		for _, e := range events {
+			m1, a1 := original.Match(e)
+			m2, a2 := optimized.Match(e)
+			if m1 != m2 || a1 != a2 {
+				t.Fatalf("condition %s event %v: original returned %t %t, optimized %t %t\n%s",
+					condition, e.Data, m1, a1, m2, a2, optimized)
+			}
+		}
+	}
+}
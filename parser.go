@@ -2,6 +2,7 @@ package sigma
 
 import (
 	"fmt"
+	"time"
 )
 
 type parser struct {
@@ -24,10 +25,29 @@ type parser struct {
 	// resulting rule that can be collected later
 	result Branch
 
+	// condition tokens that precede the pipe separator, consumed by recursive descent parser
+	cond []Item
+	// index of current token in cond
+	idx int
+	// offset of condition end, reported for errors at EOF
+	end int
+
+	// set once the pipe separator is seen, tokens that follow it belong to an aggregation expression
+	aggregation bool
+
+	// aggregation expression that follows the pipe separator, nil if rule has none
+	agg *Aggregation
+
+	// sliding window length for aggregation expressions
+	timeframe time.Duration
+
 	// if true, stops the parser from collapsing whitespace in non-regex rules (default is false to collapse)
 	// and the data that will be matched against them; default is to collapse whitespace to allow for better
 	// matching in the event that a bad actor attempts to pad whitespace inot a command to fool the engine
 	noCollapseWS bool
+
+	// pipeline transformations that apply to rule, used when selections are built
+	transforms ruleTransformations
 }
 
 func (p *parser) run() error {
@@ -46,27 +66,182 @@ func (p *parser) run() error {
 }
 
 func (p *parser) parse() error {
-	res, err := newBranch(p.sigma, p.tokens, 0, p.noCollapseWS)
+	tokens := p.tokens
+	end := len(p.condition)
+	for i, item := range p.tokens {
+		if item.T == TokSepPipe {
+			agg, err := newAggregation(p.tokens[i+1:], p.timeframe)
+			if err != nil {
+				return err
+			}
+			p.agg = agg
+			tokens = p.tokens[:i]
+			end = item.Pos
+			break
+		}
+	}
+	p.cond, p.idx, p.end = tokens, 0, end
+	res, err := p.parseOr()
 	if err != nil {
 		return err
 	}
+	if item := p.peek(); item.T != TokLitEof {
+		return p.unexpected(item, TokKeywordAnd, TokKeywordOr, TokLitEof)
+	}
 	p.result = res
 	return nil
 }
 
-// collect gathers all items from lexer and does preliminary sequence validation
+// peek returns current condition token without consuming it
+// EOF is returned past the last token
+func (p *parser) peek() Item {
+	if p.idx >= len(p.cond) {
+		return Item{T: TokLitEof, Pos: p.end}
+	}
+	return p.cond[p.idx]
+}
+
+func (p *parser) advance() Item {
+	item := p.peek()
+	if p.idx < len(p.cond) {
+		p.idx++
+	}
+	return item
+}
+
+func (p *parser) unexpected(item Item, expected ...Token) error {
+	return ErrParseCondition{
+		Condition: p.condition,
+		Pos:       item.Pos,
+		Expected:  expected,
+		Got:       item,
+	}
+}
+
+// parseOr parses disjunction, which has the lowest precedence
+// or := and ("or" and)*
+func (p *parser) parseOr() (Branch, error) {
+	b, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := NodeSimpleOr{b}
+	for p.peek().T == TokKeywordOr {
+		p.advance()
+		b, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, b)
+	}
+	return or.Reduce(), nil
+}
+
+// parseAnd parses conjunction
+// and := not ("and" not)*
+func (p *parser) parseAnd() (Branch, error) {
+	b, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	and := NodeSimpleAnd{b}
+	for p.peek().T == TokKeywordAnd {
+		p.advance()
+		b, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, b)
+	}
+	return and.Reduce(), nil
+}
+
+// parseNot parses negation, which binds tighter than and / or
+// not := "not" not | primary
+func (p *parser) parseNot() (Branch, error) {
+	if p.peek().T != TokKeywordNot {
+		return p.parsePrimary()
+	}
+	p.advance()
+	b, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return newNodeNotIfNegated(b, true), nil
+}
+
+// parsePrimary parses identifiers, quantified identifiers and groups
+// primary := "(" or ")" | ident | ("1 of" | "all of") (ident | wildcard | "them")
+func (p *parser) parsePrimary() (Branch, error) {
+	item := p.advance()
+	switch item.T {
+	case TokSepLpar:
+		b, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if next := p.peek(); next.T != TokSepRpar {
+			return nil, p.unexpected(next, TokKeywordAnd, TokKeywordOr, TokSepRpar)
+		}
+		p.advance()
+		return b, nil
+	case TokIdentifier:
+		val, ok := p.sigma[item.Val]
+		if !ok {
+			return nil, ErrMissingConditionItem{Key: item.Val}
+		}
+		return newRuleFromIdent(val, checkIdentType(item.Val, val), p.noCollapseWS, p.transforms)
+	case TokStOne, TokStAll:
+		return p.parseQuantified(item)
+	}
+	return nil, p.unexpected(item, TokIdentifier, TokKeywordNot, TokSepLpar, TokStOne, TokStAll)
+}
+
+// parseQuantified builds disjunction for 1 of and conjunction for all of statement
+func (p *parser) parseQuantified(quantifier Item) (Branch, error) {
+	target := p.advance()
+	var rules []Branch
+	var err error
+	switch target.T {
+	case TokIdentifierAll:
+		rules, err = extractAllToRules(p.sigma, p.noCollapseWS, p.transforms)
+	case TokIdentifier, TokIdentifierWithWildcard:
+		rules, err = extractAndBuildBranches(p.sigma, target.Glob(), p.noCollapseWS, p.transforms)
+		if err != nil {
+			err = fmt.Errorf("failed to extract and build branch for '%s': %w", target.Val, err)
+		}
+	default:
+		return nil, p.unexpected(target, TokIdentifierAll, TokIdentifier, TokIdentifierWithWildcard)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if quantifier.T == TokStAll {
+		return NodeSimpleAnd(rules).Reduce(), nil
+	}
+	return NodeSimpleOr(rules).Reduce(), nil
+}
+
+// collect gathers all items from lexer
+// condition syntax is validated by parser, tokens that follow the pipe separator are validated here
 func (p *parser) collect() error {
-	for item := range p.lex.items {
-		if item.T == TokUnsupp {
+	for _, item := range p.lex.items {
+		switch item.T {
+		case TokUnsupp:
 			return ErrUnsupportedToken{Msg: item.Val}
+		case TokErr:
+			return ErrParseCondition{Condition: p.condition, Pos: item.Pos, Got: item}
 		}
-		if p.previous.T != TokBegin && !validTokenSequence(p.previous.T, item.T) {
+		if p.aggregation && !validAggTokenSequence(p.previous.T, item.T) {
 			return ErrInvalidTokenSeq{
 				Prev:      p.previous,
 				Next:      item,
 				Collected: p.tokens,
 			}
 		}
+		if item.T == TokSepPipe {
+			p.aggregation = true
+		}
 		if item.T != TokLitEof {
 			p.tokens = append(p.tokens, item)
 		}
@@ -570,6 +570,512 @@ var detection15_negative4 = `
 }
 `
 
+var detection16 = `
+detection:
+  condition: "selection and not filter"
+  selection:
+    Image|endswith: '\System32\cmd.exe'
+    CommandLine|contains: 'WHOAMI'
+  filter:
+    User|cased: 'SYSTEM'
+`
+
+var detection16_positive1 = `
+{
+	"Image": "C:\\WINDOWS\\system32\\CMD.EXE",
+	"CommandLine": "cmd /c whoami /all",
+	"User": "system"
+}
+`
+
+var detection16_positive2 = `
+{
+	"Image": "c:\\windows\\system32\\cmd.exe",
+	"CommandLine": "cmd /c WhoAmI",
+	"User": "Administrator"
+}
+`
+
+var detection16_negative1 = `
+{
+	"Image": "C:\\Windows\\System32\\cmd.exe",
+	"CommandLine": "cmd /c whoami",
+	"User": "SYSTEM"
+}
+`
+
+var detection16_negative2 = `
+{
+	"Image": "C:\\Windows\\System32\\cmd.exe",
+	"CommandLine": "cmd /c hostname",
+	"User": "user"
+}
+`
+
+var detection17 = `
+detection:
+  condition: "selection and not filter"
+  selection:
+    DestinationPort|gte: 1024
+    DestinationPort|lt: '49152'
+    BytesSent|gt: 1.5
+  filter:
+    LogonType|lte:
+    - 2
+    - 3
+`
+
+var detection17_positive1 = `
+{
+	"DestinationPort": 8080,
+	"BytesSent": 1.75,
+	"LogonType": 10
+}
+`
+
+var detection17_positive2 = `
+{
+	"DestinationPort": "1024",
+	"BytesSent": "2",
+	"LogonType": "5"
+}
+`
+
+var detection17_negative1 = `
+{
+	"DestinationPort": 443,
+	"BytesSent": 100,
+	"LogonType": 10
+}
+`
+
+var detection17_negative2 = `
+{
+	"DestinationPort": 8080,
+	"BytesSent": 1.5,
+	"LogonType": 10
+}
+`
+
+var detection17_negative3 = `
+{
+	"DestinationPort": 8080,
+	"BytesSent": 100,
+	"LogonType": 3
+}
+`
+
+var detection17_negative4 = `
+{
+	"DestinationPort": "high",
+	"BytesSent": 100,
+	"LogonType": 10
+}
+`
+
+var detection18 = `
+detection:
+  condition: "selection and not filter"
+  selection:
+    DestinationIp|cidr:
+    - '10.0.0.0/8'
+    - '172.16.0.0/12'
+    - '192.168.0.0/16'
+    - 'fc00::/7'
+  filter:
+    DestinationIp|cidr: '10.10.10.10'
+`
+
+var detection18_positive1 = `
+{
+	"DestinationIp": "172.20.1.5"
+}
+`
+
+var detection18_positive2 = `
+{
+	"DestinationIp": "fd12:3456::1"
+}
+`
+
+var detection18_positive3 = `
+{
+	"DestinationIp": "::ffff:192.168.1.1"
+}
+`
+
+var detection18_negative1 = `
+{
+	"DestinationIp": "172.32.0.1"
+}
+`
+
+var detection18_negative2 = `
+{
+	"DestinationIp": "10.10.10.10"
+}
+`
+
+var detection18_negative3 = `
+{
+	"DestinationIp": "not an address"
+}
+`
+
+var detection19 = `
+detection:
+  condition: "1 of selection_*"
+  selection_encoded_powershell:
+    CommandLine|utf16le|base64offset|contains:
+    - 'IEX ('
+    - 'Net.WebClient'
+  selection_encoded_shell:
+    CommandLine|base64offset|contains|all:
+    - 'http://'
+    - '| bash'
+  selection_wide:
+    Payload|wide|contains: 'Invoke-*Shell'
+`
+
+var detection19_positive1 = `
+{
+	"CommandLine": "powershell -enc cABvAHcAZQByAHMAaABlAGwAbAAgAC0AbgBvAHAAIAAtAHcAIABoAGkAZABkAGUAbgAgAEkARQBYACAAKABOAGUAdwAtAE8AYgBqAGUAYwB0ACAATgBlAHQALgBXAGUAYgBDAGwAaQBlAG4AdAApAA=="
+}
+`
+
+var detection19_positive2 = `
+{
+	"CommandLine": "echo eHggaHR0cDovL2V2aWwvYS5zaCB8IGJhc2g= | base64 -d | sh"
+}
+`
+
+var detection19_positive3 = `
+{
+	"CommandLine": "none",
+	"Payload": "\u0000I\u0000n\u0000v\u0000o\u0000k\u0000e\u0000-\u0000P\u0000o\u0000w\u0000e\u0000r\u0000S\u0000h\u0000e\u0000l\u0000l\u0000"
+}
+`
+
+var detection19_negative1 = `
+{
+	"CommandLine": "powershell -enc RwBlAHQALQBQAHIAbwBjAGUAcwBzAA=="
+}
+`
+
+var detection19_negative2 = `
+{
+	"CommandLine": "echo Y3VybCBodHRwOi8veC95 | base64 -d | sh"
+}
+`
+
+var detection19_negative3 = `
+{
+	"CommandLine": "powershell -enc cabvahcazqbyahmaaabladwabaagac0abgbvahaaiaatahcaiaboagkazabkaguabgagaekarqbyacaakaboaguadwatae8aygbqaguaywb0acaatgbladqalgbxaguaygbdagwaaqblag4adaapaa=="
+}
+`
+
+var detection20 = `
+detection:
+  condition: selection_flags and selection_image
+  selection_flags:
+    CommandLine|windash|contains|all:
+    - ' -nop '
+    - ' -enc '
+  selection_image:
+    CommandLine|windash|startswith: '-exec'
+    Image|windash|endswith: ' -h'
+`
+
+var detection20_positive1 = `
+{
+	"CommandLine": "-exec bypass -nop /enc abc",
+	"Image": "tool -h"
+}
+`
+
+var detection20_positive2 = `
+{
+	"CommandLine": "\u2013Exec bypass \u2014NoP \u2015enc abc",
+	"Image": "tool /h"
+}
+`
+
+var detection20_negative1 = `
+{
+	"CommandLine": "-exec bypass -nop abc",
+	"Image": "tool -h"
+}
+`
+
+var detection20_negative2 = `
+{
+	"CommandLine": "-exec bypass x-nop -enc abc",
+	"Image": "tool -h"
+}
+`
+
+var detection20_negative3 = `
+{
+	"CommandLine": "exec bypass -nop -enc abc",
+	"Image": "tool -h"
+}
+`
+
+var detection21 = `
+detection:
+  condition: selection
+  selection:
+    CommandLine|re|i: '^powershell.*-enc'
+    Script|re|m: '^Invoke-Expression$'
+    Payload|re|s|i: 'begin.+end'
+`
+
+var detection21_positive = `
+{
+	"CommandLine": "PowerShell.exe -Enc abc",
+	"Script": "$a = 1\nInvoke-Expression\n$b = 2",
+	"Payload": "BEGIN\nfoo\nEND"
+}
+`
+
+var detection21_negative1 = `
+{
+	"CommandLine": "PowerShell.exe -Enc abc",
+	"Script": "$a = 1; Invoke-Expression $b",
+	"Payload": "BEGIN\nfoo\nEND"
+}
+`
+
+var detection21_negative2 = `
+{
+	"CommandLine": "cmd.exe /c powershell -enc abc",
+	"Script": "Invoke-Expression",
+	"Payload": "begin end"
+}
+`
+
+var detection22 = `
+detection:
+  condition: selection and not filter
+  selection:
+    CommandLine|exists: true
+    ParentImage: null
+  filter:
+    User|exists: false
+`
+
+var detection22_positive1 = `
+{
+	"CommandLine": "whoami",
+	"User": "admin"
+}
+`
+
+var detection22_positive2 = `
+{
+	"CommandLine": "whoami",
+	"ParentImage": null,
+	"User": "admin"
+}
+`
+
+var detection22_negative1 = `
+{
+	"User": "admin"
+}
+`
+
+var detection22_negative2 = `
+{
+	"CommandLine": "whoami",
+	"ParentImage": "explorer.exe",
+	"User": "admin"
+}
+`
+
+var detection22_negative3 = `
+{
+	"CommandLine": "whoami"
+}
+`
+
+var detection23 = `
+detection:
+  condition: selection_image or selection_user
+  selection_image:
+    Image|fieldref: ParentImage
+  selection_user:
+    TargetUser|fieldref|startswith:
+    - SubjectUser
+    - SubjectDomain
+    LogonId|fieldref|contains|all:
+    - SubjectLogonId
+    - TargetLogonId
+`
+
+var detection23_positive1 = `
+{
+	"Image": "C:\\Windows\\cmd.exe",
+	"ParentImage": "c:\\windows\\CMD.EXE",
+	"TargetUser": "x",
+	"LogonId": "x"
+}
+`
+
+var detection23_positive2 = `
+{
+	"Image": "C:\\Windows\\cmd.exe",
+	"ParentImage": "C:\\Windows\\explorer.exe",
+	"TargetUser": "ADMIN$",
+	"SubjectUser": "admin",
+	"LogonId": "0x3e7-0x3e8",
+	"SubjectLogonId": "0x3e7",
+	"TargetLogonId": "0x3e8"
+}
+`
+
+var detection23_negative1 = `
+{
+	"Image": "C:\\Windows\\cmd.exe",
+	"ParentImage": "C:\\Windows\\explorer.exe",
+	"TargetUser": "admin",
+	"SubjectUser": "bob",
+	"LogonId": "0x3e7-0x3e8",
+	"SubjectLogonId": "0x3e7",
+	"TargetLogonId": "0x3e8"
+}
+`
+
+var detection23_negative2 = `
+{
+	"Image": "C:\\Windows\\cmd.exe",
+	"TargetUser": "admin",
+	"SubjectUser": "admin",
+	"LogonId": "0x3e7",
+	"SubjectLogonId": "0x3e7",
+	"TargetLogonId": "0x3e8"
+}
+`
+
+var detection24 = `
+detection:
+  condition: selection
+  selection:
+    mfa_enabled: false
+    public: true
+    risk_score: 7.5
+    api_version:
+    - 1
+    - 1.5
+    flags:
+    - true
+    - false
+    status: 'true'
+`
+
+var detection24_positive1 = `
+{
+	"mfa_enabled": false,
+	"public": true,
+	"risk_score": 7.5,
+	"api_version": 1,
+	"flags": false,
+	"status": true
+}
+`
+
+var detection24_positive2 = `
+{
+	"mfa_enabled": "False",
+	"public": 1,
+	"risk_score": "7.50",
+	"api_version": "1.5",
+	"flags": "TRUE",
+	"status": "True"
+}
+`
+
+var detection24_negative1 = `
+{
+	"mfa_enabled": true,
+	"public": true,
+	"risk_score": 7.5,
+	"api_version": 1,
+	"flags": false,
+	"status": true
+}
+`
+
+var detection24_negative2 = `
+{
+	"mfa_enabled": false,
+	"public": 2,
+	"risk_score": 7.5,
+	"api_version": 1,
+	"flags": false,
+	"status": true
+}
+`
+
+var detection24_negative3 = `
+{
+	"mfa_enabled": false,
+	"public": true,
+	"risk_score": 7.49,
+	"api_version": 1.25,
+	"flags": "yes",
+	"status": false
+}
+`
+
+var detection25 = `
+detection:
+  condition: selection
+  selection:
+    Args|contains|all:
+    - '-enc'
+    - 'bypass'
+    Answers|cidr: '10.0.0.0/8'
+    Ports: 443
+    Details|endswith: '.exe'
+`
+
+var detection25_positive = `
+{
+	"Args": ["powershell", "-exec bypass -enc", "abc"],
+	"Answers": ["192.168.1.1", "10.1.2.3"],
+	"Ports": [80, "443"],
+	"Details": ["DWORD (0x00000001)", "C:\\Temp\\evil.EXE"]
+}
+`
+
+var detection25_negative1 = `
+{
+	"Args": ["powershell", "-enc", "bypass"],
+	"Answers": ["192.168.1.1", "10.1.2.3"],
+	"Ports": [80, 443],
+	"Details": ["C:\\Temp\\evil.exe"]
+}
+`
+
+var detection25_negative2 = `
+{
+	"Args": ["powershell", "-exec bypass -enc", "abc"],
+	"Answers": ["192.168.1.1"],
+	"Ports": [80, 443],
+	"Details": ["C:\\Temp\\evil.exe"]
+}
+`
+
+var detection25_negative3 = `
+{
+	"Args": ["powershell", "-exec bypass -enc", "abc"],
+	"Answers": ["10.1.2.3"],
+	"Ports": [],
+	"Details": ["C:\\Temp\\evil.exe"]
+}
+`
+
 type parseTestCase struct {
 	ID              int
 	Rule            string
@@ -674,6 +1180,66 @@ var parseTestCases = []parseTestCase{
 		Pos:  []string{detection15_positive1, detection15_positive2},
 		Neg:  []string{detection15_negative1, detection15_negative2, detection15_negative3, detection15_negative4},
 	},
+	{
+		ID:   16,
+		Rule: detection16,
+		Pos:  []string{detection16_positive1, detection16_positive2},
+		Neg:  []string{detection16_negative1, detection16_negative2},
+	},
+	{
+		ID:   17,
+		Rule: detection17,
+		Pos:  []string{detection17_positive1, detection17_positive2},
+		Neg:  []string{detection17_negative1, detection17_negative2, detection17_negative3, detection17_negative4},
+	},
+	{
+		ID:   18,
+		Rule: detection18,
+		Pos:  []string{detection18_positive1, detection18_positive2, detection18_positive3},
+		Neg:  []string{detection18_negative1, detection18_negative2, detection18_negative3},
+	},
+	{
+		ID:   19,
+		Rule: detection19,
+		Pos:  []string{detection19_positive1, detection19_positive2, detection19_positive3},
+		Neg:  []string{detection19_negative1, detection19_negative2, detection19_negative3},
+	},
+	{
+		ID:   20,
+		Rule: detection20,
+		Pos:  []string{detection20_positive1, detection20_positive2},
+		Neg:  []string{detection20_negative1, detection20_negative2, detection20_negative3},
+	},
+	{
+		ID:   21,
+		Rule: detection21,
+		Pos:  []string{detection21_positive},
+		Neg:  []string{detection21_negative1, detection21_negative2},
+	},
+	{
+		ID:   22,
+		Rule: detection22,
+		Pos:  []string{detection22_positive1, detection22_positive2},
+		Neg:  []string{detection22_negative1, detection22_negative2, detection22_negative3},
+	},
+	{
+		ID:   23,
+		Rule: detection23,
+		Pos:  []string{detection23_positive1, detection23_positive2},
+		Neg:  []string{detection23_negative1, detection23_negative2},
+	},
+	{
+		ID:   24,
+		Rule: detection24,
+		Pos:  []string{detection24_positive1, detection24_positive2},
+		Neg:  []string{detection24_negative1, detection24_negative2, detection24_negative3},
+	},
+	{
+		ID:   25,
+		Rule: detection25,
+		Pos:  []string{detection25_positive},
+		Neg:  []string{detection25_negative1, detection25_negative2, detection25_negative3},
+	},
 }
 
 func TestTokenCollect(t *testing.T) {
@@ -790,3 +1356,79 @@ func TestSigmaEscape(t *testing.T) {
 		})
 	}
 }
+
+func TestConditionPrecedence(t *testing.T) {
+	detection := Detection{
+		"a":     map[interface{}]interface{}{"A": "x"},
+		"b":     map[interface{}]interface{}{"B": "x"},
+		"sel_c": map[interface{}]interface{}{"C": "x"},
+	}
+	cases := []struct {
+		Condition string
+		Expected  func(a, b, c bool) bool
+	}{
+		{Condition: "a or b and sel_c", Expected: func(a, b, c bool) bool { return a || (b && c) }},
+		{Condition: "a and b or sel_c", Expected: func(a, b, c bool) bool { return (a && b) || c }},
+		{Condition: "not a or b", Expected: func(a, b, c bool) bool { return !a || b }},
+		{Condition: "not (a or b) and sel_c", Expected: func(a, b, c bool) bool { return !(a || b) && c }},
+		{Condition: "not not a", Expected: func(a, b, c bool) bool { return a }},
+		{Condition: "a and not(b or sel_c)", Expected: func(a, b, c bool) bool { return a && !(b || c) }},
+		{Condition: "b or not 1 of sel_* and a", Expected: func(a, b, c bool) bool { return b || (!c && a) }},
+		{Condition: "(1 of them) and not all of them", Expected: func(a, b, c bool) bool {
+			return (a || b || c) && !(a && b && c)
+		}},
+		{Condition: "All Of sel_* or a", Expected: func(a, b, c bool) bool { return c || a }},
+	}
+	value := func(v bool) string {
+		if v {
+			return "x"
+		}
+		return "y"
+	}
+	for i, c := range cases {
+		p := &parser{lex: lex(c.Condition), condition: c.Condition, sigma: detection}
+		if err := p.run(); err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		for mask := 0; mask < 8; mask++ {
+			a, b, sc := mask&1 != 0, mask&2 != 0, mask&4 != 0
+			e := NewMapEvent(map[string]interface{}{"A": value(a), "B": value(b), "C": value(sc)})
+			if m, _ := p.result.Match(e); m != c.Expected(a, b, sc) {
+				t.Fatalf("case %d: %s with a=%t b=%t c=%t expected %t got %t",
+					i, c.Condition, a, b, sc, !m, m)
+			}
+		}
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	detection := Detection{
+		"selection": map[interface{}]interface{}{"A": "x"},
+		"filter":    map[interface{}]interface{}{"B": "x"},
+	}
+	cases := []struct {
+		Condition string
+		Pos       int
+		Got       Token
+	}{
+		{Condition: "selection and", Pos: 13, Got: TokLitEof},
+		{Condition: "selection filter", Pos: 10, Got: TokIdentifier},
+		{Condition: "(selection or filter", Pos: 20, Got: TokLitEof},
+		{Condition: "selection )", Pos: 10, Got: TokSepRpar},
+		{Condition: "selection and or filter", Pos: 14, Got: TokKeywordOr},
+		{Condition: "1 of and filter", Pos: 5, Got: TokKeywordAnd},
+		{Condition: "filter or sel*", Pos: 10, Got: TokIdentifierWithWildcard},
+		{Condition: "selection and not | count() > 1", Pos: 18, Got: TokLitEof},
+	}
+	for i, c := range cases {
+		p := &parser{lex: lex(c.Condition), condition: c.Condition, sigma: detection}
+		err := p.run()
+		e, ok := err.(ErrParseCondition)
+		if !ok {
+			t.Fatalf("case %d: %s expected ErrParseCondition got %v", i, c.Condition, err)
+		}
+		if e.Pos != c.Pos || e.Got.T != c.Got || len(e.Expected) == 0 {
+			t.Fatalf("case %d: %s unexpected error %s", i, c.Condition, e)
+		}
+	}
+}
@@ -29,14 +29,14 @@ const (
 // NumMatcher is an atomic pattern for numeric item or list of items
 type NumMatcher interface {
 	// NumMatch implements NumMatcher
-	NumMatch(int) bool
+	NumMatch(Number) bool
 }
 
 // NumMatchers holds multiple numeric matchers
 type NumMatchers []NumMatcher
 
 // NumMatch implements NumMatcher
-func (n NumMatchers) NumMatch(val int) bool {
+func (n NumMatchers) NumMatch(val Number) bool {
 	for _, v := range n {
 		if v.NumMatch(val) {
 			return true
@@ -45,13 +45,20 @@ func (n NumMatchers) NumMatch(val int) bool {
 	return false
 }
 
-func NewNumMatcher(patterns ...int) (NumMatcher, error) {
+// NewNumMatcher creates numeric matcher that compares values to patterns with op
+// TokOpEq is used for equality, TokOpGt, TokOpGte, TokOpLt and TokOpLte for range comparisons
+func NewNumMatcher(op Token, patterns ...Number) (NumMatcher, error) {
 	if len(patterns) == 0 {
 		return nil, fmt.Errorf("no patterns defined for matcher object")
 	}
+	switch op {
+	case TokOpEq, TokOpGt, TokOpGte, TokOpLt, TokOpLte:
+	default:
+		return nil, fmt.Errorf("invalid numeric comparison operator %s", op)
+	}
 	matcher := make(NumMatchers, 0)
 	for _, p := range patterns {
-		matcher = append(matcher, NumPattern{Val: p})
+		matcher = append(matcher, NumPattern{Op: op, Val: p})
 	}
 
 	return func() NumMatcher {
@@ -175,12 +182,20 @@ func NewStringMatcher(
 	}
 	matcher := make([]StringMatcher, 0)
 	for _, p := range patterns {
+		// regular expressions are never lowercased, as that would change escape sequences like \S
+		// case insensitive flag is used instead
+		// other patterns are lowercased once here and wrapped in LowercasePattern below,
+		// so they compare tokens as is against message that was already lowercased
+		raw := p
+		if lower {
+			p = strings.ToLower(p)
+		}
 		// process modifiers first
 		switch mod {
 		case TextPatternRegex: // regex per spec
-			re, err := regexp.Compile(p)
+			re, err := regexp.Compile(caseInsensitiveRegexIfNeeded(raw, lower))
 			if err != nil {
-				return nil, err
+				return nil, ErrInvalidRegex{Pattern: raw, Err: err}
 			}
 			matcher = append(matcher, RegexPattern{Re: re})
 		case TextPatternContains: // contains: puts * wildcards around the values, such that the value is matched anywhere in the field.
@@ -192,19 +207,20 @@ func NewStringMatcher(
 			if err != nil {
 				return nil, err
 			}
-			matcher = append(matcher, GlobPattern{Glob: &globNG, NoCollapseWS: noCollapseWS})
+			matcher = append(matcher, GlobPattern{Glob: &globNG, Pattern: p, NoCollapseWS: noCollapseWS})
 		case TextPatternSuffix:
 			p = handleWhitespace(p, noCollapseWS)
-			matcher = append(matcher, SuffixPattern{Token: p, Lowercase: lower, NoCollapseWS: noCollapseWS})
+			matcher = append(matcher, SuffixPattern{Token: p, NoCollapseWS: noCollapseWS})
 		case TextPatternPrefix:
 			p = handleWhitespace(p, noCollapseWS)
-			matcher = append(matcher, PrefixPattern{Token: p, Lowercase: lower, NoCollapseWS: noCollapseWS})
+			matcher = append(matcher, PrefixPattern{Token: p, NoCollapseWS: noCollapseWS})
 		default:
 			// no (supported) modifiers, handle non-spec regex, globs and regular values
 			if strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
-				re, err := regexp.Compile(strings.TrimLeft(strings.TrimRight(p, "/"), "/"))
+				expr := strings.TrimLeft(strings.TrimRight(raw, "/"), "/")
+				re, err := regexp.Compile(caseInsensitiveRegexIfNeeded(expr, lower))
 				if err != nil {
-					return nil, err
+					return nil, ErrInvalidRegex{Pattern: expr, Err: err}
 				}
 				matcher = append(matcher, RegexPattern{Re: re})
 			} else if mod == TextPatternKeyword {
@@ -220,7 +236,7 @@ func NewStringMatcher(
 				if err != nil {
 					return nil, err
 				}
-				matcher = append(matcher, GlobPattern{Glob: &globNG, NoCollapseWS: noCollapseWS})
+				matcher = append(matcher, GlobPattern{Glob: &globNG, Pattern: p, NoCollapseWS: noCollapseWS})
 			} else if strings.Contains(p, "*") {
 				p = handleWhitespace(p, noCollapseWS)
 				// Do NOT call QuoteMeta here as we're assuming the author knows what they're doing...
@@ -229,14 +245,14 @@ func NewStringMatcher(
 				if err != nil {
 					return nil, err
 				}
-				matcher = append(matcher, GlobPattern{Glob: &globNG, NoCollapseWS: noCollapseWS})
+				matcher = append(matcher, GlobPattern{Glob: &globNG, Pattern: p, NoCollapseWS: noCollapseWS})
 			} else {
 				p = handleWhitespace(p, noCollapseWS)
-				matcher = append(matcher, ContentPattern{Token: p, Lowercase: lower, NoCollapseWS: noCollapseWS})
+				matcher = append(matcher, ContentPattern{Token: p, NoCollapseWS: noCollapseWS})
 			}
 		}
 	}
-	return func() StringMatcher {
+	m := func() StringMatcher {
 		if len(matcher) == 1 {
 			return matcher[0]
 		}
@@ -244,7 +260,55 @@ func NewStringMatcher(
 			return StringMatchersConj(matcher).Optimize()
 		}
 		return StringMatchers(matcher).Optimize()
-	}(), nil
+	}()
+	if lower {
+		// patterns are already lowercased, so message only needs to be lowercased once for all of them
+		return LowercasePattern{S: m}, nil
+	}
+	return m, nil
+}
+
+// caseInsensitiveRegexIfNeeded sets case insensitive flag for regular expressions that are matched
+// against lowercased messages, otherwise uppercase character classes would never match
+func caseInsensitiveRegexIfNeeded(re string, lower bool) string {
+	if lower {
+		return "(?i)" + re
+	}
+	return re
+}
+
+// LowercasePattern wraps patterns that were lowercased when rule was compiled
+// Message is lowercased once and then passed to all wrapped patterns, rather than
+// lowercasing both sides on every comparison
+type LowercasePattern struct {
+	S StringMatcher
+}
+
+// StringMatch implements StringMatcher
+func (l LowercasePattern) StringMatch(msg string) bool {
+	return l.S.StringMatch(strings.ToLower(msg))
+}
+
+// lowerCache remembers last lowercased value, so that selection items
+// matching the same value only lowercase it once
+type lowerCache struct {
+	raw, lower string
+	set        bool
+}
+
+func (c *lowerCache) get(msg string) string {
+	if !c.set || c.raw != msg {
+		c.raw, c.lower, c.set = msg, strings.ToLower(msg), true
+	}
+	return c.lower
+}
+
+// stringMatch matches msg against m, using lowercased value from cache for LowercasePattern
+func (c *lowerCache) stringMatch(m StringMatcher, msg string) bool {
+	if l, ok := m.(LowercasePattern); ok {
+		return l.S.StringMatch(c.get(msg))
+	}
+	return m.StringMatch(msg)
 }
 
 // StringMatchers holds multiple atomic matchers
@@ -300,12 +364,15 @@ func optimizeStringMatchers(s []StringMatcher) []StringMatcher {
 	literals := make([]StringMatcher, 0)
 	for _, pat := range s {
 		switch pat.(type) {
-		case ContentPattern, PrefixPattern, SuffixPattern:
+		case ContentPattern, PrefixPattern, SuffixPattern, SimplePattern:
 			literals = append(literals, pat)
-		case GlobPattern:
+		case GlobPattern, BytePattern:
 			globs = append(globs, pat)
 		case RegexPattern:
 			re = append(re, pat)
+		default:
+			// nested or unknown matchers have unknown cost, so they are evaluated last
+			re = append(re, pat)
 		}
 	}
 	return append(literals, append(globs, re...)...)
@@ -313,7 +380,9 @@ func optimizeStringMatchers(s []StringMatcher) []StringMatcher {
 
 // ContentPattern is a token for literal content matching
 type ContentPattern struct {
-	Token        string
+	Token string
+	// Deprecated: Lowercase lowercases both sides on every comparison
+	// NewStringMatcher lowercases tokens when rule is built and wraps patterns in LowercasePattern instead
 	Lowercase    bool
 	NoCollapseWS bool
 }
@@ -326,7 +395,9 @@ func (c ContentPattern) StringMatch(msg string) bool {
 
 // PrefixPattern is a token for literal content matching
 type PrefixPattern struct {
-	Token        string
+	Token string
+	// Deprecated: Lowercase lowercases both sides on every comparison
+	// NewStringMatcher lowercases tokens when rule is built and wraps patterns in LowercasePattern instead
 	Lowercase    bool
 	NoCollapseWS bool
 }
@@ -342,7 +413,9 @@ func (c PrefixPattern) StringMatch(msg string) bool {
 
 // SuffixPattern is a token for literal content matching
 type SuffixPattern struct {
-	Token        string
+	Token string
+	// Deprecated: Lowercase lowercases both sides on every comparison
+	// NewStringMatcher lowercases tokens when rule is built and wraps patterns in LowercasePattern instead
 	Lowercase    bool
 	NoCollapseWS bool
 }
@@ -369,6 +442,7 @@ func (r RegexPattern) StringMatch(msg string) bool {
 // GlobPattern is similar to ContentPattern but allows for asterisk wildcards
 type GlobPattern struct {
 	Glob         *glob.Glob
+	Pattern      string // escaped glob string that Glob was compiled from
 	NoCollapseWS bool
 }
 
@@ -390,6 +464,7 @@ func (s SimplePattern) StringMatch(msg string) bool {
 	return strings.Contains(msg, s.Token)
 }
 
+// lowerCaseIfNeeded is only used by deprecated Lowercase pattern fields
 func lowerCaseIfNeeded(str string, lower bool) string {
 	if lower {
 		return strings.ToLower(str)
@@ -398,11 +473,28 @@ func lowerCaseIfNeeded(str string, lower bool) string {
 }
 
 // NumPattern matches on numeric value
+// Op is the comparison operator, zero value means equality
 type NumPattern struct {
-	Val int
+	Op  Token
+	Val Number
 }
 
 // NumMatch implements NumMatcher
-func (n NumPattern) NumMatch(val int) bool {
-	return n.Val == val
+func (n NumPattern) NumMatch(val Number) bool {
+	c, ok := val.Compare(n.Val)
+	if !ok {
+		return false
+	}
+	switch n.Op {
+	case TokOpGt:
+		return c > 0
+	case TokOpGte:
+		return c >= 0
+	case TokOpLt:
+		return c < 0
+	case TokOpLte:
+		return c <= 0
+	default:
+		return c == 0
+	}
 }
@@ -0,0 +1,276 @@
+package sigma
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Pipeline transformation types
+const (
+	// TransformFieldNameMapping renames rule fields to event fields
+	TransformFieldNameMapping = "field_name_mapping"
+	// TransformAddCondition adds selection that must match along with rule detection
+	TransformAddCondition = "add_condition"
+	// TransformDropDetectionItem removes selection items for listed fields
+	TransformDropDetectionItem = "drop_detection_item"
+	// TransformReplaceString replaces regular expression matches in selection values
+	TransformReplaceString = "replace_string"
+)
+
+// Pipeline is a processing pipeline that adapts rules to event schema, similar to pySigma pipelines
+// Transformations are applied when selections are built, so rules do not need to be modified
+type Pipeline struct {
+	Name string `yaml:"name" json:"name"`
+	// Priority orders pipelines, lower value is applied first
+	Priority        int              `yaml:"priority" json:"priority"`
+	Transformations []Transformation `yaml:"transformations" json:"transformations"`
+}
+
+// Transformation is a single step of pipeline
+type Transformation struct {
+	ID   string `yaml:"id" json:"id,omitempty"`
+	Type string `yaml:"type" json:"type"`
+
+	// Mapping renames rule fields, used by field_name_mapping
+	Mapping map[string]string `yaml:"mapping" json:"mapping,omitempty"`
+	// Conditions are selection items joined with rule detection by logical conjunction, used by add_condition
+	// Fields are not renamed, as they are expected to be in event schema already
+	Conditions map[string]interface{} `yaml:"conditions" json:"conditions,omitempty"`
+	// Fields limits drop_detection_item and replace_string to listed fields
+	// Names are compared after preceding transformations have been applied
+	Fields []string `yaml:"fields" json:"fields,omitempty"`
+	// Regex and Replacement are used by replace_string
+	Regex       string `yaml:"regex" json:"regex,omitempty"`
+	Replacement string `yaml:"replacement" json:"replacement,omitempty"`
+
+	// RuleConditions restrict transformation to rules that satisfy all conditions
+	RuleConditions []PipelineCondition `yaml:"rule_conditions" json:"rule_conditions,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// PipelineCondition restricts transformation to some rules
+// Only logsource conditions are supported, every field that is set must equal the one in rule logsource
+type PipelineCondition struct {
+	Type      string `yaml:"type" json:"type"`
+	Logsource `yaml:",inline"`
+}
+
+// NewPipeline parses and validates pipeline yaml
+func NewPipeline(data []byte) (*Pipeline, error) {
+	var p Pipeline
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// LoadPipeline reads pipeline yaml from file
+func LoadPipeline(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPipeline(data)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *Pipeline) compile() error {
+	for i := range p.Transformations {
+		t := &p.Transformations[i]
+		switch t.Type {
+		case TransformFieldNameMapping:
+			if len(t.Mapping) == 0 {
+				return fmt.Errorf("transformation %d: %s requires mapping", i, t.Type)
+			}
+		case TransformAddCondition:
+			if len(t.Conditions) == 0 {
+				return fmt.Errorf("transformation %d: %s requires conditions", i, t.Type)
+			}
+		case TransformDropDetectionItem:
+			if len(t.Fields) == 0 {
+				return fmt.Errorf("transformation %d: %s requires fields", i, t.Type)
+			}
+		case TransformReplaceString:
+			re, err := regexp.Compile(t.Regex)
+			if err != nil {
+				return fmt.Errorf("transformation %d: %s", i, err)
+			}
+			t.re = re
+		default:
+			return fmt.Errorf("transformation %d: unsupported type %s", i, t.Type)
+		}
+		for _, c := range t.RuleConditions {
+			if c.Type != "logsource" {
+				return fmt.Errorf("transformation %d: unsupported rule condition %s", i, c.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether condition field values are equal to those of rule logsource
+func (c PipelineCondition) matches(ls Logsource) bool {
+	match := func(cond, rule string) bool {
+		return cond == "" || strings.EqualFold(cond, rule)
+	}
+	return match(c.Product, ls.Product) &&
+		match(c.Category, ls.Category) &&
+		match(c.Service, ls.Service)
+}
+
+// ruleTransformations holds pipeline transformations that apply to a single rule, in order
+type ruleTransformations []*Transformation
+
+func newRuleTransformations(pipelines []*Pipeline, ls Logsource) ruleTransformations {
+	if len(pipelines) == 0 {
+		return nil
+	}
+	sorted := make([]*Pipeline, len(pipelines))
+	copy(sorted, pipelines)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+	var out ruleTransformations
+	for _, p := range sorted {
+		for i := range p.Transformations {
+			if t := &p.Transformations[i]; t.appliesToRule(ls) {
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+func (t Transformation) appliesToRule(ls Logsource) bool {
+	for _, c := range t.RuleConditions {
+		if !c.matches(ls) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditions returns selections that are added to rule detection
+func (r ruleTransformations) conditions() []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, t := range r {
+		if t.Type == TransformAddCondition {
+			out = append(out, t.Conditions)
+		}
+	}
+	return out
+}
+
+// mapSelection applies transformations to selection keys and values
+// Returned map is a copy, so detection of rule is not modified
+func (r ruleTransformations) mapSelection(expr map[string]interface{}) (map[string]interface{}, error) {
+	if len(r) == 0 {
+		return expr, nil
+	}
+	out := make(map[string]interface{}, len(expr))
+	for key, val := range expr {
+		field, mods := key, ""
+		if i := strings.Index(key, "|"); i >= 0 {
+			field, mods = key[:i], key[i:]
+		}
+		// fieldref values are field names, so they are renamed rather than rewritten
+		fieldref := strings.Contains(mods+"|", "|fieldref|")
+		if fieldref {
+			val = r.mapFieldRefs(val)
+		}
+		drop := false
+		for _, t := range r {
+			switch t.Type {
+			case TransformFieldNameMapping:
+				if mapped, ok := t.Mapping[field]; ok {
+					field = mapped
+				}
+			case TransformDropDetectionItem:
+				drop = drop || t.appliesTo(field)
+			case TransformReplaceString:
+				if !fieldref && t.appliesTo(field) {
+					val = t.replace(val)
+				}
+			}
+		}
+		if drop {
+			continue
+		}
+		if _, ok := out[field+mods]; ok {
+			return nil, fmt.Errorf("selection key %s is mapped to %s, which is already used", key, field+mods)
+		}
+		out[field+mods] = val
+	}
+	if len(out) == 0 && len(expr) > 0 {
+		// empty selection would match every event
+		return nil, fmt.Errorf("all selection items were dropped by pipeline")
+	}
+	return out, nil
+}
+
+// fieldName applies field name mappings to field
+func (r ruleTransformations) fieldName(field string) string {
+	for _, t := range r {
+		if t.Type != TransformFieldNameMapping {
+			continue
+		}
+		if mapped, ok := t.Mapping[field]; ok {
+			field = mapped
+		}
+	}
+	return field
+}
+
+// mapFieldRefs renames fields referenced by fieldref values, other values are returned as is
+func (r ruleTransformations) mapFieldRefs(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return r.fieldName(v)
+	case []interface{}:
+		tx := make([]interface{}, len(v))
+		for i, item := range v {
+			tx[i] = r.mapFieldRefs(item)
+		}
+		return tx
+	}
+	return val
+}
+
+func (t Transformation) appliesTo(field string) bool {
+	if len(t.Fields) == 0 {
+		return true
+	}
+	for _, f := range t.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// replace rewrites string values, other values are returned as is
+func (t Transformation) replace(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return t.re.ReplaceAllString(v, t.Replacement)
+	case []interface{}:
+		tx := make([]interface{}, len(v))
+		for i, item := range v {
+			tx[i] = t.replace(item)
+		}
+		return tx
+	}
+	return val
+}
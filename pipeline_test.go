@@ -0,0 +1,296 @@
+package sigma
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+var ecsPipeline = `
+name: ecs
+priority: 10
+transformations:
+  - id: process_creation_fields
+    type: field_name_mapping
+    mapping:
+      Image: process.executable
+      CommandLine: process.command_line
+      User: user.name
+    rule_conditions:
+      - type: logsource
+        product: windows
+        category: process_creation
+  - id: sysmon_module
+    type: add_condition
+    conditions:
+      event.module: sysmon
+    rule_conditions:
+      - type: logsource
+        service: sysmon
+  - id: no_hashes
+    type: drop_detection_item
+    fields:
+      - Hashes
+  - id: system_root
+    type: replace_string
+    regex: '(?i)^C:\\Windows\\'
+    replacement: '%SystemRoot%\'
+    fields:
+      - process.executable
+`
+
+var pipelineRule = `
+id: pipeline
+logsource:
+  product: windows
+  category: process_creation
+  service: sysmon
+detection:
+  condition: selection and not filter
+  selection:
+    Image: 'C:\Windows\System32\whoami.exe'
+    CommandLine|contains: '/priv'
+    Hashes: 'MD5=1234'
+  filter:
+    User: 'admin'
+`
+
+func TestPipeline(t *testing.T) {
+	p, err := NewPipeline([]byte(ecsPipeline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rule Rule
+	if err := yaml.Unmarshal([]byte(pipelineRule), &rule); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTree(RuleHandle{Rule: rule, Pipelines: []*Pipeline{p}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range []struct {
+		event map[string]interface{}
+		match bool
+	}{
+		{
+			event: map[string]interface{}{
+				"event.module":         "sysmon",
+				"process.executable":   `%SystemRoot%\System32\whoami.exe`,
+				"process.command_line": "whoami /priv",
+				"user.name":            "bob",
+			},
+			match: true,
+		},
+		{
+			// added condition must match
+			event: map[string]interface{}{
+				"event.module":         "security",
+				"process.executable":   `%SystemRoot%\System32\whoami.exe`,
+				"process.command_line": "whoami /priv",
+				"user.name":            "bob",
+			},
+		},
+		{
+			// filter uses mapped field as well
+			event: map[string]interface{}{
+				"event.module":         "sysmon",
+				"process.executable":   `%SystemRoot%\System32\whoami.exe`,
+				"process.command_line": "whoami /priv",
+				"user.name":            "admin",
+			},
+		},
+		{
+			// original field names are no longer used
+			event: map[string]interface{}{
+				"event.module": "sysmon",
+				"Image":        `C:\Windows\System32\whoami.exe`,
+				"CommandLine":  "whoami /priv",
+				"Hashes":       "MD5=1234",
+			},
+		},
+	} {
+		if match, _ := tree.Match(NewMapEvent(c.event)); match != c.match {
+			t.Fatalf("pipeline case %d returned %t, expected %t\n%s", i, match, c.match, tree)
+		}
+	}
+	if rule.Detection["selection"].(map[interface{}]interface{})["Image"] != `C:\Windows\System32\whoami.exe` {
+		t.Fatal("pipeline modified rule detection")
+	}
+
+	// rules for other logsources are not transformed
+	rule.Logsource = Logsource{Product: "linux", Category: "process_creation"}
+	tree, err = NewTree(RuleHandle{Rule: rule, Pipelines: []*Pipeline{p}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := map[string]interface{}{
+		"Image":       `C:\Windows\System32\whoami.exe`,
+		"CommandLine": "whoami /priv",
+		"Hashes":      "MD5=other",
+		"User":        "bob",
+	}
+	if match, _ := tree.Match(NewMapEvent(event)); !match {
+		t.Fatalf("rule for other logsource should use original fields\n%s", tree)
+	}
+
+	// referenced fields are mapped as well
+	rule.Logsource = Logsource{Product: "windows", Category: "process_creation"}
+	rule.Detection = Detection{
+		"condition": "selection",
+		"selection": map[interface{}]interface{}{"Image|fieldref": "CommandLine"},
+	}
+	tree, err = NewTree(RuleHandle{Rule: rule, Pipelines: []*Pipeline{p}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = map[string]interface{}{
+		"process.executable":   `C:\Windows\System32\whoami.exe`,
+		"process.command_line": `C:\Windows\System32\whoami.exe`,
+	}
+	if match, _ := tree.Match(NewMapEvent(event)); !match {
+		t.Fatalf("fieldref should use mapped fields\n%s", tree)
+	}
+}
+
+func TestPipelineAggregation(t *testing.T) {
+	p, err := NewPipeline([]byte(`
+transformations:
+  - type: field_name_mapping
+    mapping:
+      Image: process.executable
+      User: user.name
+      Computer: host.name
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTree(RuleHandle{Pipelines: []*Pipeline{p}, Rule: Rule{Detection: Detection{
+		"condition": "sel | count(Image) by User > 0",
+		"sel":       map[interface{}]interface{}{"Image|endswith": "cmd.exe"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Agg.Field != "process.executable" || tree.Agg.GroupBy != "user.name" {
+		t.Fatalf("aggregation fields were not mapped: %s", tree.Agg)
+	}
+	if _, match := tree.Eval(NewMapEvent(map[string]interface{}{
+		"process.executable": `C:\Windows\System32\cmd.exe`,
+		"user.name":          "bob",
+	})); !match {
+		t.Fatal("aggregation over mapped fields should match")
+	}
+
+	c, err := NewCorrelator(RuleHandle{Pipelines: []*Pipeline{p}, Rule: Rule{ID: "corr", Correlation: &Correlation{
+		Type:      "value_count",
+		Rules:     []string{"a", "b"},
+		GroupBy:   []string{"User"},
+		Timespan:  "5m",
+		Condition: map[string]interface{}{"gte": 2, "field": "Image"},
+		Aliases:   map[string]map[string]string{"User": {"b": "Computer"}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.GroupBy, []string{"user.name"}) || c.Field != "process.executable" ||
+		c.aliases[0]["b"] != "host.name" {
+		t.Fatalf("correlation fields were not mapped: %v %s %v", c.GroupBy, c.Field, c.aliases)
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	for _, raw := range []string{`
+transformations:
+  - type: unknown
+`, `
+transformations:
+  - type: replace_string
+    regex: '('
+`, `
+transformations:
+  - type: field_name_mapping
+    mapping:
+      Image: process.executable
+    rule_conditions:
+      - type: rule_id
+`} {
+		if _, err := NewPipeline([]byte(raw)); err == nil {
+			t.Fatalf("pipeline should fail to load:%s", raw)
+		}
+	}
+
+	p, err := NewPipeline([]byte(`
+transformations:
+  - type: field_name_mapping
+    mapping:
+      Image: process.executable
+      TargetImage: process.executable
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewTree(RuleHandle{Pipelines: []*Pipeline{p}, Rule: Rule{Detection: Detection{
+		"condition": "selection",
+		"selection": map[interface{}]interface{}{"Image": "a", "TargetImage": "b"},
+	}}})
+	if err == nil {
+		t.Fatal("mapping two fields to the same key should fail")
+	}
+
+	// dropping every item must not leave a selection that matches all events
+	p, err = NewPipeline([]byte(`
+transformations:
+  - type: drop_detection_item
+    fields:
+      - Hashes
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTree(RuleHandle{Pipelines: []*Pipeline{p}, Rule: Rule{Detection: Detection{
+		"condition": "sel",
+		"sel":       map[interface{}]interface{}{"Hashes": "abc"},
+	}}})
+	if err == nil {
+		match, _ := tree.Match(NewMapEvent(map[string]interface{}{"foo": "bar"}))
+		t.Fatalf("dropping all selection items should fail, rule matched unrelated event: %t", match)
+	}
+}
+
+func TestRulesetPipelines(t *testing.T) {
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "rules")
+	if err := os.Mkdir(rules, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rules, "rule.yml"), []byte(pipelineRule), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pipeline := filepath.Join(dir, "ecs.yml")
+	if err := os.WriteFile(pipeline, []byte(ecsPipeline), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rs, err := NewRuleset(Config{Directory: []string{rules}, Pipelines: []string{pipeline}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Ok != 1 {
+		t.Fatalf("ruleset parsed %d rules, expected 1", rs.Ok)
+	}
+	results, match := rs.EvalAll(NewMapEvent(map[string]interface{}{
+		"event.module":         "sysmon",
+		"process.executable":   `%SystemRoot%\System32\whoami.exe`,
+		"process.command_line": "whoami /priv",
+		"user.name":            "bob",
+	}))
+	if !match || results[0].ID != "pipeline" {
+		t.Fatalf("ruleset with pipeline did not match, got %v", results)
+	}
+
+	if _, err := NewRuleset(Config{Directory: []string{rules}, Pipelines: []string{filepath.Join(dir, "missing.yml")}}, nil); err == nil {
+		t.Fatal("missing pipeline file should fail ruleset")
+	}
+}
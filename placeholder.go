@@ -0,0 +1,206 @@
+package sigma
+
+import (
+	"regexp"
+	"strings"
+)
+
+// PlaceholderProvider resolves %name% placeholders in values with expand modifier
+// Values are environment specific, for example admin account lists or internal subnets
+type PlaceholderProvider interface {
+	// Placeholder implements PlaceholderProvider
+	Placeholder(name string) ([]string, bool)
+}
+
+// Placeholders is a static PlaceholderProvider
+type Placeholders map[string][]string
+
+// Placeholder implements PlaceholderProvider
+func (p Placeholders) Placeholder(name string) ([]string, bool) {
+	val, ok := p[name]
+	return val, ok
+}
+
+// PlaceholderPolicy defines how rules with unresolved placeholders are handled
+type PlaceholderPolicy int
+
+const (
+	// PlaceholderFail counts rule as failed
+	PlaceholderFail PlaceholderPolicy = iota
+	// PlaceholderDrop silently skips the rule, it is counted as unsupported
+	PlaceholderDrop
+)
+
+var rePlaceholder = regexp.MustCompile(`%([^%\s]+)%`)
+
+// ExpandPlaceholders returns a copy of rule handle with placeholders in detection resolved
+// Rule handle is returned as is when it does not use expand modifier
+func (r RuleHandle) ExpandPlaceholders(p PlaceholderProvider) (RuleHandle, error) {
+	d, err := expandPlaceholders(r.Detection, p)
+	if err != nil {
+		if e, ok := err.(ErrUnresolvedPlaceholder); ok {
+			e.Rule = r.ID
+			return r, e
+		}
+		return r, err
+	}
+	r.Detection = d
+	return r, nil
+}
+
+// expandPlaceholders returns a copy of detection where values with expand modifier
+// are replaced by placeholder values, the modifier itself is removed from keys
+// Detection is returned as is when no expand modifier is used
+func expandPlaceholders(d Detection, p PlaceholderProvider) (Detection, error) {
+	if !detectionHasExpand(d) {
+		return d, nil
+	}
+	tx := make(Detection, len(d))
+	for k, v := range d {
+		if k == "condition" || k == "timeframe" {
+			tx[k] = v
+			continue
+		}
+		expanded, err := expandIdent(v, p)
+		if err != nil {
+			return nil, err
+		}
+		tx[k] = expanded
+	}
+	return tx, nil
+}
+
+func detectionHasExpand(d Detection) bool {
+	var found bool
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch val := v.(type) {
+		case map[interface{}]interface{}:
+			for k, item := range val {
+				if hasModifier(k, "expand") {
+					found = true
+					return
+				}
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range val {
+				walk(item)
+			}
+		}
+	}
+	for _, v := range d.Extract() {
+		walk(v)
+	}
+	return found
+}
+
+// expandIdent walks selection maps, lists of maps are handled recursively
+func expandIdent(v interface{}, p PlaceholderProvider) (interface{}, error) {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		tx := make(map[interface{}]interface{}, len(val))
+		for k, item := range val {
+			if !hasModifier(k, "expand") {
+				tx[k] = copyYAMLValue(item)
+				continue
+			}
+			expanded, err := expandValues(item, p)
+			if err != nil {
+				return nil, err
+			}
+			tx[removeModifier(k, "expand")] = expanded
+		}
+		return tx, nil
+	case []interface{}:
+		tx := make([]interface{}, len(val))
+		for i, item := range val {
+			expanded, err := expandIdent(item, p)
+			if err != nil {
+				return nil, err
+			}
+			tx[i] = expanded
+		}
+		return tx, nil
+	default:
+		return v, nil
+	}
+}
+
+// expandValues replaces placeholders in a value or list of values
+// Placeholder with multiple values produces one value for each of them
+func expandValues(v interface{}, p PlaceholderProvider) (interface{}, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		items = []interface{}{v}
+	}
+	tx := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			tx = append(tx, item)
+			continue
+		}
+		expanded, err := expandString(str, p)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range expanded {
+			tx = append(tx, e)
+		}
+	}
+	return tx, nil
+}
+
+func expandString(in string, p PlaceholderProvider) ([]string, error) {
+	loc := rePlaceholder.FindStringSubmatchIndex(in)
+	if loc == nil {
+		return []string{in}, nil
+	}
+	name := in[loc[2]:loc[3]]
+	var values []string
+	if p != nil {
+		values, _ = p.Placeholder(name)
+	}
+	if len(values) == 0 {
+		return nil, ErrUnresolvedPlaceholder{Name: name}
+	}
+	// remainder may hold more placeholders
+	rest, err := expandString(in[loc[1]:], p)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(values)*len(rest))
+	for _, val := range values {
+		for _, r := range rest {
+			out = append(out, in[:loc[0]]+val+r)
+		}
+	}
+	return out, nil
+}
+
+func hasModifier(key interface{}, mod string) bool {
+	str, ok := key.(string)
+	if !ok {
+		return false
+	}
+	bits := strings.Split(str, "|")
+	for _, b := range bits[1:] {
+		if b == mod {
+			return true
+		}
+	}
+	return false
+}
+
+func removeModifier(key interface{}, mod string) string {
+	bits := strings.Split(key.(string), "|")
+	tx := make([]string, 0, len(bits))
+	tx = append(tx, bits[0])
+	for _, b := range bits[1:] {
+		if b != mod {
+			tx = append(tx, b)
+		}
+	}
+	return strings.Join(tx, "|")
+}
@@ -0,0 +1,104 @@
+package sigma
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/markuskont/datamodels"
+	"gopkg.in/yaml.v2"
+)
+
+var placeholderRule = `
+title: Admin logon from workstation
+id: 1f0d3f2c-5c3b-4f0e-9d6a-2f4c3c1a8b01
+detection:
+  selection:
+    User|expand: '%admins%'
+    Workstation|expand|startswith:
+    - '%prefix%-%site%'
+  filter:
+    Workstation: 'dc01'
+  condition: selection and not filter
+`
+
+func TestExpandString(t *testing.T) {
+	p := Placeholders{
+		"admins": {"root", "administrator"},
+		"prefix": {"ws"},
+		"site":   {"tll", "trt"},
+	}
+	cases := []struct {
+		In       string
+		Expected []string
+	}{
+		{In: "plain", Expected: []string{"plain"}},
+		{In: "%admins%", Expected: []string{"root", "administrator"}},
+		{In: "%prefix%-%site%*", Expected: []string{"ws-tll*", "ws-trt*"}},
+		{In: "100% sure", Expected: []string{"100% sure"}},
+	}
+	for i, c := range cases {
+		out, err := expandString(c.In, p)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(out, c.Expected) {
+			t.Fatalf("case %d: expected %q got %q", i, c.Expected, out)
+		}
+	}
+	if _, err := expandString("%missing%", p); err == nil {
+		t.Fatal("unresolved placeholder should return error")
+	}
+}
+
+func TestRulesetPlaceholders(t *testing.T) {
+	var rule Rule
+	if err := yaml.Unmarshal([]byte(placeholderRule), &rule); err != nil {
+		t.Fatal(err)
+	}
+	handles := []RuleHandle{{Rule: rule}}
+
+	if _, err := handles[0].ExpandPlaceholders(nil); err == nil {
+		t.Fatal("rule without placeholder provider should fail to expand")
+	} else if e, ok := err.(ErrUnresolvedPlaceholder); !ok || e.Rule != rule.ID {
+		t.Fatalf("expected ErrUnresolvedPlaceholder for rule, got %v", err)
+	}
+
+	dropped, _ := buildSnapshot(handles, Placeholders{}, PlaceholderDrop, nil)
+	if dropped.Unsupported != 1 || dropped.Failed != 0 || len(dropped.Rules) != 0 {
+		t.Fatalf("drop policy should skip rule, got %d unsupported %d failed",
+			dropped.Unsupported, dropped.Failed)
+	}
+	failed, _ := buildSnapshot(handles, Placeholders{}, PlaceholderFail, nil)
+	if failed.Failed != 1 || len(failed.Rules) != 0 {
+		t.Fatalf("fail policy should fail rule, got %d failed", failed.Failed)
+	}
+
+	ruleset := RulesetFromRuleList(handles)
+	if stats := ruleset.ReloadPlaceholders(Placeholders{
+		"admins": {"root"},
+		"prefix": {"ws"},
+		"site":   {"tll"},
+	}); stats.Added != 1 {
+		t.Fatalf("reload should add rule, got %+v", stats)
+	}
+	if loaded := ruleset.Snapshot(); len(loaded.Rules) != 1 {
+		t.Fatalf("rule should be loaded, got %d failed", loaded.Failed)
+	}
+	event := datamodels.Map{"User": "Administrator", "Workstation": "WS-TRT-042"}
+	if _, match := ruleset.EvalAll(event); match {
+		t.Fatal("event should not match before reload")
+	}
+	if stats := ruleset.ReloadPlaceholders(Placeholders{
+		"admins": {"root", "administrator"},
+		"prefix": {"ws"},
+		"site":   {"tll", "trt"},
+	}); stats.Changed != 1 {
+		t.Fatalf("reload should change rule, got %+v", stats)
+	}
+	if _, match := ruleset.EvalAll(event); !match {
+		t.Fatal("event should match after reload")
+	}
+	if _, ok := rule.Detection["selection"].(map[interface{}]interface{})["User|expand"]; !ok {
+		t.Fatal("expansion should not modify original rule")
+	}
+}
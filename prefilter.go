@@ -0,0 +1,312 @@
+package sigma
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// anchor is a literal that must be found in a field for rule to match
+// Literals are lowercased and field values are lowercased before scanning, which is
+// a necessary condition for both case sensitive and insensitive patterns
+type anchor struct {
+	anchorField
+	literal string
+}
+
+// anchorField is event field that anchors are searched from
+type anchorField struct {
+	key string
+	// keyword anchors are searched from Event.Keywords rather than selected field
+	keyword bool
+}
+
+// prefilter finds rules that could match an event by scanning event fields for mandatory literals
+// Every field has a single automaton holding literals of all rules
+type prefilter struct {
+	rules  []*Tree
+	fields []*fieldFilter
+	// always holds rules that have no mandatory literals, they are evaluated for every event
+	always []int
+}
+
+type fieldFilter struct {
+	anchorField
+	literals []string
+	// rules that are enabled when literal with the same index is found
+	rules [][]int
+	ac    *ahoCorasick
+}
+
+func newPrefilter(rules []*Tree) *prefilter {
+	p := &prefilter{rules: rules}
+	byField := make(map[anchorField]*fieldFilter)
+	literalIdx := make(map[anchor]int)
+	for i, rule := range rules {
+		anchors, ok := ruleAnchors(rule.Root)
+		if !ok {
+			p.always = append(p.always, i)
+			continue
+		}
+		for _, a := range anchors {
+			f, ok := byField[a.anchorField]
+			if !ok {
+				f = &fieldFilter{anchorField: a.anchorField}
+				byField[a.anchorField] = f
+				p.fields = append(p.fields, f)
+			}
+			idx, ok := literalIdx[a]
+			if !ok {
+				idx = len(f.literals)
+				literalIdx[a] = idx
+				f.literals = append(f.literals, a.literal)
+				f.rules = append(f.rules, nil)
+			}
+			// rule may have the same literal in multiple alternatives
+			if n := len(f.rules[idx]); n == 0 || f.rules[idx][n-1] != i {
+				f.rules[idx] = append(f.rules[idx], i)
+			}
+		}
+	}
+	sort.Slice(p.fields, func(i, j int) bool {
+		if p.fields[i].keyword != p.fields[j].keyword {
+			return p.fields[i].keyword
+		}
+		return p.fields[i].key < p.fields[j].key
+	})
+	for _, f := range p.fields {
+		f.ac = newAhoCorasick(f.literals)
+	}
+	return p
+}
+
+// candidates returns rules that need to be evaluated for event, indexed the same way as rules
+// Nil is returned if filter was built for a different rule list, meaning all rules must be evaluated
+func (p *prefilter) candidates(rules []*Tree, e Event) []bool {
+	if p == nil || !sameRules(rules, p.rules) {
+		return nil
+	}
+	out := make([]bool, len(rules))
+	for _, i := range p.always {
+		out[i] = true
+	}
+	enable := func(f *fieldFilter) func(int) {
+		return func(id int) {
+			for _, i := range f.rules[id] {
+				out[i] = true
+			}
+		}
+	}
+	for _, f := range p.fields {
+		if f.keyword {
+			values, ok := e.Keywords()
+			if !ok {
+				continue
+			}
+			for _, v := range values {
+				f.ac.scan(strings.ToLower(v), enable(f))
+			}
+			continue
+		}
+		val, ok := e.Select(f.key)
+		if !ok {
+			continue
+		}
+		// values are converted the same way as for selection string items
+		elems, isArray := arrayValue(val)
+		if !isArray {
+			elems = []interface{}{val}
+		}
+		for _, elem := range elems {
+			if str, ok := stringValue(elem); ok {
+				f.ac.scan(strings.ToLower(str), enable(f))
+			}
+		}
+	}
+	return out
+}
+
+// ruleAnchors returns literals of which at least one must be present for branch to match
+// False is returned if no such set exists, e.g. for negations or numeric comparisons
+func ruleAnchors(b Branch) ([]anchor, bool) {
+	if children, ok := conjunctionChildren(b); ok {
+		return bestAnchors(children)
+	}
+	if children, ok := disjunctionChildren(b); ok {
+		var out []anchor
+		for _, c := range children {
+			anchors, ok := ruleAnchors(c)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, anchors...)
+		}
+		return out, true
+	}
+	switch v := indirectNode(b).(type) {
+	case Keyword:
+		literals, ok := patternLiterals(v.S)
+		if !ok {
+			return nil, false
+		}
+		out := make([]anchor, 0, len(literals))
+		for _, l := range literals {
+			out = append(out, anchor{anchorField: anchorField{keyword: true}, literal: l})
+		}
+		return out, true
+	case Selection:
+		// every item must match, so literals of any string item are mandatory
+		var best []anchor
+		for _, item := range v.S {
+			literals, ok := patternLiterals(item.Pattern)
+			if !ok {
+				continue
+			}
+			anchors := make([]anchor, 0, len(literals))
+			for _, l := range literals {
+				anchors = append(anchors, anchor{anchorField: anchorField{key: item.Key}, literal: l})
+			}
+			if best == nil || betterAnchors(anchors, best) {
+				best = anchors
+			}
+		}
+		return best, best != nil
+	}
+	return nil, false
+}
+
+// bestAnchors picks the most selective anchors from conjunction, as any of them is mandatory
+func bestAnchors(children []Branch) ([]anchor, bool) {
+	var best []anchor
+	for _, c := range children {
+		anchors, ok := ruleAnchors(c)
+		if !ok {
+			continue
+		}
+		if best == nil || betterAnchors(anchors, best) {
+			best = anchors
+		}
+	}
+	return best, best != nil
+}
+
+// betterAnchors prefers anchor sets with longer literals, as they are less likely to be found
+func betterAnchors(a, b []anchor) bool {
+	minLen := func(anchors []anchor) int {
+		l := -1
+		for _, a := range anchors {
+			if l < 0 || len(a.literal) < l {
+				l = len(a.literal)
+			}
+		}
+		return l
+	}
+	if la, lb := minLen(a), minLen(b); la != lb {
+		return la > lb
+	}
+	return len(a) < len(b)
+}
+
+// patternLiterals returns lowercased literals of which at least one is contained in any matching value
+func patternLiterals(m StringMatcher) ([]string, bool) {
+	switch v := m.(type) {
+	case ContentPattern:
+		return stringLiteral(v.Token)
+	case PrefixPattern:
+		return stringLiteral(v.Token)
+	case SuffixPattern:
+		return stringLiteral(v.Token)
+	case SimplePattern:
+		return stringLiteral(v.Token)
+	case GlobPattern:
+		return globLiteral(v.Pattern)
+	case LowercasePattern:
+		return patternLiterals(v.S)
+	case StringMatchers:
+		var out []string
+		for _, p := range v {
+			literals, ok := patternLiterals(p)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, literals...)
+		}
+		return out, true
+	case StringMatchersConj:
+		var best []string
+		for _, p := range v {
+			literals, ok := patternLiterals(p)
+			if !ok {
+				continue
+			}
+			if best == nil || len(shortest(literals)) > len(shortest(best)) {
+				best = literals
+			}
+		}
+		return best, best != nil
+	}
+	return nil, false
+}
+
+// stringLiteral returns the longest part of s that has no whitespace
+// Whitespace is collapsed in both pattern and value, so only parts between whitespace are matched verbatim
+func stringLiteral(s string) ([]string, bool) {
+	var best string
+	for _, part := range strings.FieldsFunc(s, unicode.IsSpace) {
+		if len(part) > len(best) {
+			best = part
+		}
+	}
+	// lowercasing replaces invalid utf8 in values, so such literals might not be found
+	if best == "" || !utf8.ValidString(best) || strings.ContainsRune(best, utf8.RuneError) {
+		return nil, false
+	}
+	return []string{strings.ToLower(best)}, true
+}
+
+// globLiteral returns the longest literal part of escaped glob pattern
+func globLiteral(pattern string) ([]string, bool) {
+	if pattern == "" {
+		return nil, false
+	}
+	var best string
+	var current strings.Builder
+	flush := func() {
+		if literals, ok := stringLiteral(current.String()); ok && len(literals[0]) > len(best) {
+			best = literals[0]
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				current.WriteByte(pattern[i])
+			}
+		case '*', '?':
+			flush()
+		case '[', ']', '{', '}':
+			// character classes and alternatives are not handled
+			return nil, false
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	if best == "" {
+		return nil, false
+	}
+	return []string{best}, true
+}
+
+func shortest(literals []string) string {
+	var s string
+	for i, l := range literals {
+		if i == 0 || len(l) < len(s) {
+			s = l
+		}
+	}
+	return s
+}
@@ -0,0 +1,209 @@
+package sigma
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestAhoCorasick(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "s"}
+	ac := newAhoCorasick(patterns)
+	found := make(map[string]int)
+	ac.scan("ushers and his", func(id int) {
+		found[patterns[id]]++
+	})
+	expected := map[string]int{"he": 1, "she": 1, "hers": 1, "his": 1, "s": 3}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("aho-corasick found %v, expected %v", found, expected)
+	}
+}
+
+func TestPatternLiterals(t *testing.T) {
+	for _, c := range []struct {
+		mod      TextPatternModifier
+		patterns []string
+		expected []string
+	}{
+		{mod: TextPatternNone, patterns: []string{"Cmd.exe /c"}, expected: []string{"cmd.exe"}},
+		{mod: TextPatternContains, patterns: []string{`\Temp\`, "whoami"}, expected: []string{`\temp\`, "whoami"}},
+		{mod: TextPatternNone, patterns: []string{`*\\*.ps1?x\*`}, expected: []string{`.ps1`}},
+		{mod: TextPatternSuffix, patterns: []string{"-enc"}, expected: []string{"-enc"}},
+		{mod: TextPatternRegex, patterns: []string{"foo"}},
+		{mod: TextPatternContains, patterns: []string{"foo", " "}},
+		{mod: TextPatternNone, patterns: []string{"*"}},
+	} {
+		m, err := NewStringMatcher(c.mod, true, false, false, c.patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		literals, ok := patternLiterals(m)
+		sort.Strings(literals)
+		sort.Strings(c.expected)
+		if ok != (c.expected != nil) || !reflect.DeepEqual(literals, c.expected) {
+			t.Fatalf("patterns %v returned literals %v %t, expected %v", c.patterns, literals, ok, c.expected)
+		}
+	}
+}
+
+var prefilterRules = []string{`
+id: content
+detection:
+  condition: selection
+  selection:
+    Image: 'C:\Windows\System32\cmd.exe'
+`, `
+id: contains-all
+detection:
+  condition: selection
+  selection:
+    CommandLine|contains|all:
+      - 'whoami'
+      - '/priv'
+`, `
+id: whitespace
+detection:
+  condition: selection
+  selection:
+    CommandLine|startswith: 'net   user'
+`, `
+id: cased
+detection:
+  condition: selection
+  selection:
+    User|cased: 'SYSTEM'
+`, `
+id: glob
+detection:
+  condition: selection and not filter
+  selection:
+    Image: '*\\power*.exe'
+  filter:
+    User: 'admin'
+`, `
+id: regex-only
+detection:
+  condition: selection
+  selection:
+    CommandLine|re: '(?i)who.mi'
+`, `
+id: mixed-or
+detection:
+  condition: selection or regex
+  selection:
+    Image|endswith: 'whoami.exe'
+  regex:
+    CommandLine|re: 'priv$'
+`, `
+id: keywords
+detection:
+  condition: keywords and selection
+  keywords:
+    - 'mimikatz'
+    - 'sekurlsa'
+  selection:
+    EventID: 1
+`, `
+id: numeric
+detection:
+  condition: selection
+  selection:
+    EventID|gte: 4
+`, `
+id: windash
+detection:
+  condition: selection
+  selection:
+    CommandLine|windash|contains: ' -enc '
+`, `
+id: array
+detection:
+  condition: selection1 or selection2
+  selection1:
+    Tags: 'lateral'
+  selection2:
+    Image|contains:
+      - 'psexec'
+      - 'wmic'
+`, `
+id: not-only
+detection:
+  condition: not selection
+  selection:
+    User: 'admin'
+`, `
+id: base64
+detection:
+  condition: selection
+  selection:
+    CommandLine|base64offset|contains: 'whoami'
+`}
+
+func TestPrefilterEquivalence(t *testing.T) {
+	handles := make([]RuleHandle, 0, len(prefilterRules))
+	for _, raw := range prefilterRules {
+		var rule Rule
+		if err := yaml.Unmarshal([]byte(raw), &rule); err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, RuleHandle{Rule: rule})
+	}
+	rs := RulesetFromRuleList(handles).Snapshot()
+	if rs.Ok != len(prefilterRules) {
+		t.Fatalf("only %d rules out of %d were parsed", rs.Ok, len(prefilterRules))
+	}
+
+	values := map[string][]interface{}{
+		"Image": {
+			`C:\Windows\System32\cmd.exe`, `c:\windows\system32\CMD.EXE`, `C:\Tools\PowerShell.exe`,
+			`C:\Tools\whoami.exe`, `C:\psexec64.exe`, `C:\Windows\notepad.exe`, 42,
+		},
+		"CommandLine": {
+			"whoami /priv", "WHOAMI /all", "net user admin", "NET \t USER", "powershell -enc AAAA",
+			"powershell /enc AAAA", "mimikatz sekurlsa::logonpasswords", "d2hvYW1p", "whoami",
+		},
+		"User":    {"SYSTEM", "system", "admin", "Admin", "guest"},
+		"EventID": {1, 4, "1", 3.5, "x"},
+		"Tags":    {[]interface{}{"initial", "lateral"}, []interface{}{"none"}, "LATERAL"},
+	}
+	fields := []string{"Image", "CommandLine", "User", "EventID", "Tags"}
+	rnd := rand.New(rand.NewSource(1))
+	var skipped int
+	for i := 0; i < 5000; i++ {
+		data := make(map[string]interface{})
+		for _, f := range fields {
+			if rnd.Intn(3) == 0 {
+				continue
+			}
+			vals := values[f]
+			data[f] = vals[rnd.Intn(len(vals))]
+		}
+		e := NewMapEvent(data, "CommandLine", "Image")
+
+		var expected []string
+		for _, rule := range rs.Rules {
+			if res, match := rule.Eval(e); match {
+				expected = append(expected, res.ID)
+			}
+		}
+		var got []string
+		results, _ := rs.EvalAll(e)
+		for _, res := range results {
+			got = append(got, res.ID)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("event %v: prefiltered ruleset returned %v, expected %v", data, got, expected)
+		}
+		for _, c := range rs.prefilter.candidates(rs.Rules, e) {
+			if !c {
+				skipped++
+			}
+		}
+	}
+	if skipped == 0 {
+		t.Fatal("prefilter did not skip any rules")
+	}
+}
@@ -0,0 +1,138 @@
+package sigma
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// ReloadStats counts rule changes between two snapshots
+// Rules are identified by ID, or by title when ID is missing, documents of multipart rules are counted separately
+// Rules that fail to build are not part of snapshot, so they are counted as removed
+type ReloadStats struct {
+	Added, Removed, Changed, Unchanged int
+}
+
+// Reload reads rules from config and replaces current snapshot when they are built
+// Evaluation is not blocked, events that are already being evaluated finish on previous snapshot
+// Unchanged rules keep their aggregation state, correlation state is reset
+// Ruleset is not modified if rules can not be loaded
+func (r *Ruleset) Reload(c Config, tags []string) (ReloadStats, error) {
+	rules, fail, err := loadRuleHandles(c, tags)
+	if err != nil {
+		return ReloadStats{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next, stats := buildSnapshot(rules, c.Placeholders, c.PlaceholderPolicy, r.Snapshot())
+	r.root = c.Directory
+	r.handles = rules
+	r.yamlFailed = fail
+	r.placeholders = c.Placeholders
+	r.placeholderPolicy = c.PlaceholderPolicy
+	r.routeLogsource = c.RouteLogsource
+	r.store(next)
+	return stats, nil
+}
+
+// ruleKey identifies rule across reloads
+// Documents of multipart rule inherit the same id, so they are told apart by their position in file
+func ruleKey(r *RuleHandle) string {
+	key := r.ID
+	if key == "" {
+		key = r.Title
+	}
+	if r.Multipart {
+		key += "#" + strconv.Itoa(r.Part)
+	}
+	return key
+}
+
+// sameRule reports whether two rule handles would build the same rule
+func sameRule(a, b *RuleHandle) bool {
+	return a.NoCollapseWS == b.NoCollapseWS &&
+		reflect.DeepEqual(a.Rule, b.Rule) &&
+		reflect.DeepEqual(a.Pipelines, b.Pipelines)
+}
+
+// reusableTrees indexes rules of previous snapshot, so that unchanged rules are not built again
+type reusableTrees map[string][]*Tree
+
+func newReusableTrees(s *RulesetSnapshot) reusableTrees {
+	if s == nil {
+		return nil
+	}
+	out := make(reusableTrees, len(s.Rules))
+	for _, t := range s.Rules {
+		if t.Rule == nil {
+			continue
+		}
+		key := ruleKey(t.Rule)
+		out[key] = append(out[key], t)
+	}
+	return out
+}
+
+// take returns previous rule that is equal to r, each rule is returned only once
+func (p reusableTrees) take(r *RuleHandle) (*Tree, bool) {
+	key := ruleKey(r)
+	for i, t := range p[key] {
+		if sameRule(t.Rule, r) {
+			p[key] = append(p[key][:i:i], p[key][i+1:]...)
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// diffRules compares rules and correlations of previous snapshot to new ones
+func diffRules(previous *RulesetSnapshot, rules []*Tree, correlations []*Correlator) ReloadStats {
+	old := make(map[string][]*RuleHandle)
+	if previous != nil {
+		for _, h := range snapshotHandles(previous.Rules, previous.Correlations) {
+			key := ruleKey(h)
+			old[key] = append(old[key], h)
+		}
+	}
+	var stats ReloadStats
+	for _, h := range snapshotHandles(rules, correlations) {
+		key := ruleKey(h)
+		candidates := old[key]
+		if len(candidates) == 0 {
+			stats.Added++
+			continue
+		}
+		// prefer an equal rule when several share the same key
+		idx := 0
+		for i, c := range candidates {
+			if sameRule(c, h) {
+				idx = i
+				break
+			}
+		}
+		if sameRule(candidates[idx], h) {
+			stats.Unchanged++
+		} else {
+			stats.Changed++
+		}
+		old[key] = append(candidates[:idx:idx], candidates[idx+1:]...)
+	}
+	for _, remaining := range old {
+		stats.Removed += len(remaining)
+	}
+	return stats
+}
+
+func snapshotHandles(rules []*Tree, correlations []*Correlator) []*RuleHandle {
+	out := make([]*RuleHandle, 0, len(rules)+len(correlations))
+	for _, t := range rules {
+		if t.Rule != nil {
+			out = append(out, t.Rule)
+		}
+	}
+	for _, c := range correlations {
+		if c.Rule != nil {
+			out = append(out, c.Rule)
+		}
+	}
+	return out
+}
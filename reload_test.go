@@ -0,0 +1,192 @@
+package sigma
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func reloadRule(id, image string) string {
+	return fmt.Sprintf(`
+id: %s
+detection:
+  condition: selection
+  selection:
+    Image|endswith: '%s'
+`, id, image)
+}
+
+func writeRules(t *testing.T, dir string, rules map[string]string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range rules {
+		if err := os.WriteFile(filepath.Join(dir, name+".yml"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRulesetReload(t *testing.T) {
+	dir := t.TempDir()
+	writeRules(t, dir, map[string]string{
+		"same":    reloadRule("same", "cmd.exe"),
+		"changed": reloadRule("changed", "cmd.exe"),
+		"removed": reloadRule("removed", "cmd.exe"),
+	})
+	config := Config{Directory: []string{dir}}
+	rs, err := NewRuleset(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := rs.Snapshot()
+	if before.Ok != 3 {
+		t.Fatalf("ruleset parsed %d rules, expected 3", before.Ok)
+	}
+	event := NewMapEvent(map[string]interface{}{"Image": `C:\Windows\System32\cmd.exe`})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, match := rs.EvalAll(event); !match {
+					t.Error("event should match during reload")
+					return
+				}
+			}
+		}()
+	}
+
+	writeRules(t, dir, map[string]string{
+		"same":    reloadRule("same", "cmd.exe"),
+		"changed": reloadRule("changed", "powershell.exe"),
+		"added":   reloadRule("added", "cmd.exe"),
+		"broken":  "detection: [",
+	})
+	stats, err := rs.Reload(config, nil)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ReloadStats{Added: 1, Removed: 1, Changed: 1, Unchanged: 1}); stats != expected {
+		t.Fatalf("reload returned %+v, expected %+v", stats, expected)
+	}
+
+	after := rs.Snapshot()
+	if after.Ok != 3 || after.Failed != 1 || after.Total != 4 {
+		t.Fatalf("reloaded ruleset has %d ok, %d failed, %d total", after.Ok, after.Failed, after.Total)
+	}
+	if len(rs.Rules) != 3 || rs.Rules[0] != before.Rules[0] {
+		t.Fatal("deprecated exported fields should not be modified by reload")
+	}
+	rs.Rules[0], rs.Rules[1] = rs.Rules[1], rs.Rules[0]
+	if before.Rules[0] == rs.Rules[0] {
+		t.Fatal("deprecated exported rules should not share snapshot slice")
+	}
+	var reused bool
+	for _, old := range before.Rules {
+		for _, tree := range after.Rules {
+			if old == tree {
+				reused = reused || tree.Rule.ID == "same"
+				if tree.Rule.ID != "same" {
+					t.Fatalf("rule %s should have been rebuilt", tree.Rule.ID)
+				}
+			}
+		}
+	}
+	if !reused {
+		t.Fatal("unchanged rule should be reused")
+	}
+	results, _ := rs.EvalAll(event)
+	if len(results) != 2 {
+		t.Fatalf("reloaded ruleset returned %v", results)
+	}
+	if results, _ := before.EvalAll(event); len(results) != 3 {
+		t.Fatalf("previous snapshot should not change, got %v", results)
+	}
+
+	if _, err := rs.Reload(Config{Directory: []string{filepath.Join(dir, "missing")}}, nil); err == nil {
+		t.Fatal("reload from missing directory should fail")
+	}
+	if rs.Snapshot() != after {
+		t.Fatal("failed reload should not replace snapshot")
+	}
+}
+
+func multipartReloadRule(images ...string) string {
+	out := `
+action: global
+id: multipart
+detection:
+  condition: selection | count() > 1
+`
+	for _, image := range images {
+		out += fmt.Sprintf(`---
+detection:
+  selection:
+    Image|endswith: '%s'
+`, image)
+	}
+	return out
+}
+
+func TestRulesetReloadMultipart(t *testing.T) {
+	dir := t.TempDir()
+	writeRules(t, dir, map[string]string{"multipart": multipartReloadRule("cmd.exe", "whoami.exe")})
+	config := Config{Directory: []string{dir}}
+	rs, err := NewRuleset(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := rs.Snapshot()
+	if before.Ok != 2 {
+		t.Fatalf("ruleset parsed %d rules, expected 2", before.Ok)
+	}
+	// first match of each document only fills aggregation window
+	cmd := NewMapEvent(map[string]interface{}{"Image": `C:\Windows\System32\cmd.exe`})
+	if _, match := rs.EvalAll(cmd); match {
+		t.Fatal("aggregation should not fire on first event")
+	}
+
+	writeRules(t, dir, map[string]string{"multipart": multipartReloadRule("cmd.exe", "net.exe", "powershell.exe")})
+	stats, err := rs.Reload(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ReloadStats{Added: 1, Changed: 1, Unchanged: 1}); stats != expected {
+		t.Fatalf("reload returned %+v, expected %+v", stats, expected)
+	}
+	after := rs.Snapshot()
+	keys := make(map[string]bool)
+	for _, tree := range after.Rules {
+		keys[ruleKey(tree.Rule)] = true
+	}
+	if len(keys) != len(after.Rules) {
+		t.Fatalf("documents of multipart rule share keys %v", keys)
+	}
+	if after.Rules[0] != before.Rules[0] || after.Rules[1] == before.Rules[1] {
+		t.Fatal("only unchanged document should be reused")
+	}
+	// aggregation state of reused document is kept
+	if _, match := rs.EvalAll(cmd); !match {
+		t.Fatal("aggregation of unchanged document should keep its state")
+	}
+}
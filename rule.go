@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,9 +17,14 @@ import (
 type RuleHandle struct {
 	Rule
 
-	Path         string `json:"path"`
-	Multipart    bool   `json:"multipart"`
-	NoCollapseWS bool   `json:"noCollapseWS"`
+	Path      string `json:"path"`
+	Multipart bool   `json:"multipart"`
+	// Part is position of rule among rules of multipart file, documents share id so it tells them apart
+	Part         int  `json:"part"`
+	NoCollapseWS bool `json:"noCollapseWS"`
+
+	// Pipelines adapt rule fields and values to event schema when rule is built
+	Pipelines []*Pipeline `json:"-"`
 }
 
 // Rule defines raw rule conforming to sigma rule specification
@@ -30,6 +36,7 @@ type Rule struct {
 	Falsepositives []string `yaml:"falsepositives" json:"falsepositives"`
 	Fields         []string `yaml:"fields" json:"fields"`
 	ID             string   `yaml:"id" json:"id"`
+	Name           string   `yaml:"name" json:"name,omitempty"`
 	Level          string   `yaml:"level" json:"level"`
 	Title          string   `yaml:"title" json:"title"`
 	Status         string   `yaml:"status" json:"status"`
@@ -38,6 +45,9 @@ type Rule struct {
 	Logsource `yaml:"logsource" json:"logsource"`
 	Detection `yaml:"detection" json:"detection"`
 	Tags      `yaml:"tags" json:"tags"`
+
+	// Correlation is only defined for sigma correlation rules, that have no detection
+	Correlation *Correlation `yaml:"correlation" json:"correlation,omitempty"`
 }
 
 // HasTags returns true if the rule contains all provided tags, otherwise false
@@ -61,8 +71,110 @@ func RuleFromYAML(data []byte) (r Rule, err error) {
 }
 
 // IsMultipart checks if rule is multipart
+// i.e. document separator is found after some content
 func IsMultipart(data []byte) bool {
-	return !bytes.HasPrefix(data, []byte("---")) && bytes.Contains(data, []byte("---"))
+	var content bool
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("---")) {
+			if content {
+				return true
+			}
+			continue
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] != '#' {
+			content = true
+		}
+	}
+	return false
+}
+
+// RulesFromMultipartYAML parses every document in multipart yaml into Rule objects
+// Documents with action: global are merged into all following documents until action: reset
+// Documents with action: repeat are merged into previous rule to create a new one
+func RulesFromMultipartYAML(data []byte) ([]Rule, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var global, prev map[interface{}]interface{}
+	rules := make([]Rule, 0)
+	for {
+		var doc map[interface{}]interface{}
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if doc == nil {
+			continue
+		}
+		action := doc["action"]
+		delete(doc, "action")
+		var merged map[interface{}]interface{}
+		switch action {
+		case "global":
+			global = mergeYAMLMaps(global, doc)
+			continue
+		case "reset":
+			global = nil
+			continue
+		case "repeat":
+			if prev == nil {
+				return nil, fmt.Errorf("multipart rule repeat action without previous rule")
+			}
+			merged = mergeYAMLMaps(prev, doc)
+		case nil:
+			merged = mergeYAMLMaps(global, doc)
+		default:
+			return nil, fmt.Errorf("unknown multipart rule action %v", action)
+		}
+		prev = merged
+		raw, err := yaml.Marshal(merged)
+		if err != nil {
+			return nil, err
+		}
+		r, err := RuleFromYAML(raw)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("multipart yaml did not contain any rules")
+	}
+	return rules, nil
+}
+
+// mergeYAMLMaps returns a deep copy of base with values from update merged in
+// Nested maps are merged recursively, any other value from update replaces the one in base
+func mergeYAMLMaps(base, update map[interface{}]interface{}) map[interface{}]interface{} {
+	tx := make(map[interface{}]interface{}, len(base)+len(update))
+	for k, v := range base {
+		tx[k] = copyYAMLValue(v)
+	}
+	for k, v := range update {
+		if m, ok := v.(map[interface{}]interface{}); ok {
+			if existing, ok := tx[k].(map[interface{}]interface{}); ok {
+				tx[k] = mergeYAMLMaps(existing, m)
+				continue
+			}
+		}
+		tx[k] = copyYAMLValue(v)
+	}
+	return tx
+}
+
+func copyYAMLValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		return mergeYAMLMaps(val, nil)
+	case []interface{}:
+		tx := make([]interface{}, len(val))
+		for i, item := range val {
+			tx[i] = copyYAMLValue(item)
+		}
+		return tx
+	default:
+		return v
+	}
 }
 
 // NewRuleList 	reads a list of sigma rule paths and parses them to rule objects
@@ -78,7 +190,15 @@ loop:
 		if err != nil {
 			return nil, err
 		}
-		r, err := RuleFromYAML(data)
+		multipart := IsMultipart(data)
+		var parsed []Rule
+		if multipart {
+			parsed, err = RulesFromMultipartYAML(data)
+		} else {
+			var r Rule
+			r, err = RuleFromYAML(data)
+			parsed = []Rule{r}
+		}
 		if err != nil {
 			if skip {
 				errs = append(errs, ErrParseYaml{
@@ -91,16 +211,18 @@ loop:
 			return nil, &ErrParseYaml{Err: err, Path: path}
 		}
 
-		if !r.HasTags(tags) {
-			continue loop
+		for part, r := range parsed {
+			if !r.HasTags(tags) {
+				continue
+			}
+			rules = append(rules, RuleHandle{
+				Path:         path,
+				Rule:         r,
+				NoCollapseWS: noCollapseWS,
+				Multipart:    multipart,
+				Part:         part,
+			})
 		}
-
-		rules = append(rules, RuleHandle{
-			Path:         path,
-			Rule:         r,
-			NoCollapseWS: noCollapseWS,
-			Multipart:    IsMultipart(data),
-		})
 	}
 	return rules, func() error {
 		if len(errs) > 0 {
@@ -123,16 +245,33 @@ type Logsource struct {
 // contains condition expression and identifier fields for building AST
 type Detection map[string]interface{}
 
+// Extract returns identifier fields, leaving out condition and timeframe
 func (d Detection) Extract() map[string]interface{} {
 	tx := make(map[string]interface{})
 	for k, v := range d {
-		if k != "condition" {
+		if k != "condition" && k != "timeframe" {
 			tx[k] = v
 		}
 	}
 	return tx
 }
 
+// Correlation represents the correlation field in sigma correlation rule
+// Correlation rules reference other rules by id or name and fire when their
+// matches satisfy the condition within timespan
+type Correlation struct {
+	Type     string   `yaml:"type" json:"type"`
+	Rules    []string `yaml:"rules" json:"rules"`
+	GroupBy  []string `yaml:"group-by" json:"group-by"`
+	Timespan string   `yaml:"timespan" json:"timespan"`
+	// Condition holds comparison operators such as gte, with field name for value_count
+	Condition map[string]interface{} `yaml:"condition" json:"condition"`
+	// Generate keeps results of referenced rules, they are suppressed by default
+	Generate bool `yaml:"generate" json:"generate"`
+	// Aliases map group-by field name to a different field per referenced rule
+	Aliases map[string]map[string]string `yaml:"aliases" json:"aliases"`
+}
+
 // Tags contains a metadata list for tying positive matches together with other threat intel sources
 // For example, for attaching MITRE ATT&CK tactics or techniques to the event
 type Tags []string
@@ -0,0 +1,105 @@
+package sigma
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var multipartRule1 = `
+action: global
+title: Suspicious process
+id: 21b6f3d5-6f44-4a6b-9a5f-2c7f5a7b1e01
+tags:
+  - attack.execution
+detection:
+  condition: selection
+---
+logsource:
+  product: windows
+  category: process_creation
+detection:
+  selection:
+    Image: '*\whoami.exe'
+---
+action: repeat
+logsource:
+  product: windows
+  service: sysmon
+---
+action: reset
+---
+title: Linux process
+logsource:
+  product: linux
+detection:
+  selection:
+    exe: /usr/bin/whoami
+  condition: selection
+`
+
+func TestIsMultipart(t *testing.T) {
+	cases := []struct {
+		Data      string
+		Multipart bool
+	}{
+		{Data: multipartRule1, Multipart: true},
+		{Data: "---\ntitle: a\ndetection:\n  condition: selection\n", Multipart: false},
+		{Data: "# comment\n---\ntitle: a\n---\ntitle: b\n", Multipart: true},
+		{Data: detection1, Multipart: false},
+	}
+	for i, c := range cases {
+		if m := IsMultipart([]byte(c.Data)); m != c.Multipart {
+			t.Fatalf("multipart case %d expected %t got %t", i, c.Multipart, m)
+		}
+	}
+}
+
+func TestRulesFromMultipartYAML(t *testing.T) {
+	rules, err := RulesFromMultipartYAML([]byte(multipartRule1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules, got %d", len(rules))
+	}
+	for i, r := range rules[:2] {
+		if r.Title != "Suspicious process" || !r.HasTags([]string{"attack.execution"}) {
+			t.Fatalf("rule %d missing global fields: %+v", i, r)
+		}
+		if r.Detection["condition"] != "selection" || r.Detection["selection"] == nil {
+			t.Fatalf("rule %d detection not merged: %+v", i, r.Detection)
+		}
+		if _, err := NewTree(RuleHandle{Rule: r}); err != nil {
+			t.Fatalf("rule %d failed to build tree: %s", i, err)
+		}
+	}
+	if rules[0].Logsource.Category != "process_creation" || rules[0].Logsource.Service != "" {
+		t.Fatalf("unexpected logsource for first rule: %+v", rules[0].Logsource)
+	}
+	if rules[1].Logsource.Category != "process_creation" || rules[1].Logsource.Service != "sysmon" {
+		t.Fatalf("repeat action should extend previous rule: %+v", rules[1].Logsource)
+	}
+	if rules[2].ID != "" || rules[2].Title != "Linux process" || len(rules[2].Tags) != 0 {
+		t.Fatalf("reset action should drop global fields: %+v", rules[2])
+	}
+}
+
+func TestNewRuleListMultipart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "multipart.yml")
+	if err := os.WriteFile(path, []byte(multipartRule1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := NewRuleList([]string{path}, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rule handles, got %d", len(rules))
+	}
+	ruleset := RulesetFromRuleList(rules)
+	if ruleset.Ok != 3 || ruleset.Unsupported != 0 {
+		t.Fatalf("expected 3 working rules, got %d ok and %d unsupported", ruleset.Ok, ruleset.Unsupported)
+	}
+}
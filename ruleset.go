@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 // Config is used as argument to creating a new ruleset
@@ -18,6 +19,16 @@ type Config struct {
 	// by default, we will collapse whitespace for both rules and data of non-regex rules and non-regex compared data
 	// setthig this to true turns that behavior off
 	NoCollapseWS bool
+	// Placeholders resolves %name% placeholders in values with expand modifier
+	// values can be replaced later with Ruleset.ReloadPlaceholders
+	Placeholders PlaceholderProvider
+	// PlaceholderPolicy defines what happens to rules with unresolved placeholders
+	PlaceholderPolicy PlaceholderPolicy
+	// Pipelines are paths to pipeline yaml files that adapt rules to event schema
+	Pipelines []string
+	// RouteLogsource makes EvalAll evaluate events that implement Logsourcer only against
+	// rules for their logsource, Ruleset.EvalLogsource can be used without it
+	RouteLogsource bool
 }
 
 func (c Config) validate() error {
@@ -37,23 +48,95 @@ func (c Config) validate() error {
 }
 
 // Ruleset is a collection of rules
+// Events are evaluated against a snapshot of rules that is replaced atomically on reload,
+// so ruleset can be used from multiple goroutines while it is being reloaded
 type Ruleset struct {
+	// mu serializes reloads and guards inputs for rebuilding rules
 	mu *sync.RWMutex
+	// state holds current *RulesetSnapshot
+	state atomic.Value
 
+	// Deprecated: use Snapshot, Rules is a copy of rules set when ruleset is created
+	// Evaluation does not read it, so modifying it has no effect, and it is not updated on reload
 	Rules []*Tree
-	root  []string
+	// Correlations consume results of Rules, ordered so that chained correlations
+	// are evaluated after the correlations they reference
+	//
+	// Deprecated: use Snapshot, Correlations is a copy of correlations set when ruleset is created
+	// Evaluation does not read it, so modifying it has no effect, and it is not updated on reload
+	Correlations []*Correlator
+	root         []string
 
+	// handles and yaml failure count are kept, so that ruleset can be rebuilt
+	// with new placeholder values without reading rule files again
+	handles           []RuleHandle
+	yamlFailed        int
+	placeholders      PlaceholderProvider
+	placeholderPolicy PlaceholderPolicy
+	routeLogsource    bool
+
+	// Deprecated: use Snapshot, counters are set when ruleset is created and are not updated on reload
+	// Evaluation does not read them
 	Total, Ok, Failed, Unsupported int
 }
 
+// RulesetSnapshot is an immutable set of compiled rules
+// Rules and Correlations must not be modified
+type RulesetSnapshot struct {
+	Rules        []*Tree
+	Correlations []*Correlator
+
+	Total, Ok, Failed, Unsupported int
+
+	// suppressed holds IDs of rules that only feed correlations and should not
+	// produce results on their own
+	suppressed map[string]bool
+
+	// prefilter skips rules whose mandatory literals are not found in event
+	prefilter *prefilter
+	// logsources skips rules that were written for other logsources
+	logsources *logsourceIndex
+	// routeLogsource enables routing of Logsourcer events in EvalAll
+	routeLogsource bool
+}
+
 // NewRuleset instanciates a Ruleset object
 func NewRuleset(c Config, tags []string) (*Ruleset, error) {
-	if err := c.validate(); err != nil {
+	rules, fail, err := loadRuleHandles(c, tags)
+	if err != nil {
 		return nil, err
 	}
+	result := &Ruleset{
+		mu:                &sync.RWMutex{},
+		root:              c.Directory,
+		handles:           rules,
+		yamlFailed:        fail,
+		placeholders:      c.Placeholders,
+		placeholderPolicy: c.PlaceholderPolicy,
+		routeLogsource:    c.RouteLogsource,
+	}
+	first, _ := buildSnapshot(rules, c.Placeholders, c.PlaceholderPolicy, nil)
+	result.init(first)
+	return result, nil
+}
+
+// loadRuleHandles reads rule files and pipelines defined in config
+// Returns parsed rules along with number of files that failed yaml parsing
+func loadRuleHandles(c Config, tags []string) ([]RuleHandle, int, error) {
+	if err := c.validate(); err != nil {
+		return nil, 0, err
+	}
 	files, err := NewRuleFileList(c.Directory)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	pipelines := make([]*Pipeline, 0, len(c.Pipelines))
+	for _, path := range c.Pipelines {
+		p, err := LoadPipeline(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		pipelines = append(pipelines, p)
 	}
 	var fail int
 	rules, err := NewRuleList(files, !c.FailOnYamlParse, c.NoCollapseWS, tags)
@@ -62,23 +145,98 @@ func NewRuleset(c Config, tags []string) (*Ruleset, error) {
 		case ErrBulkParseYaml:
 			fail += len(e.Errs)
 		default:
-			return nil, err
+			return nil, 0, err
 		}
 	}
-	result := RulesetFromRuleList(rules)
-	result.root = c.Directory
-	result.Failed += fail
-	result.Total += fail
-	return result, nil
+	for i := range rules {
+		rules[i].Pipelines = pipelines
+	}
+	return rules, fail, nil
 }
 
 func RulesetFromRuleList(rules []RuleHandle) *Ruleset {
+	result := &Ruleset{
+		mu:                &sync.RWMutex{},
+		handles:           rules,
+		placeholderPolicy: PlaceholderFail,
+	}
+	first, _ := buildSnapshot(rules, nil, PlaceholderFail, nil)
+	result.init(first)
+	return result
+}
+
+// Snapshot returns current set of rules
+// Snapshot stays the same when ruleset is reloaded, so it can be used for a consistent view of rules
+func (r *Ruleset) Snapshot() *RulesetSnapshot {
+	return r.state.Load().(*RulesetSnapshot)
+}
+
+// init stores first snapshot and sets deprecated exported fields, which are never written again
+func (r *Ruleset) init(first *RulesetSnapshot) {
+	r.store(first)
+	// copies, so that callers that still reorder exported slices do not modify snapshot
+	r.Rules = append([]*Tree(nil), first.Rules...)
+	r.Correlations = append([]*Correlator(nil), first.Correlations...)
+	r.Total, r.Ok, r.Failed, r.Unsupported = first.Total, first.Ok, first.Failed, first.Unsupported
+}
+
+// store replaces current snapshot, caller must hold write lock unless ruleset is not shared yet
+// yaml failures are counted here, as they are not known to snapshot builder
+func (r *Ruleset) store(next *RulesetSnapshot) {
+	next.Failed += r.yamlFailed
+	next.Total += r.yamlFailed
+	next.routeLogsource = r.routeLogsource
+	r.state.Store(next)
+}
+
+// ReloadPlaceholders rebuilds rules from already parsed rule handles with new placeholder values
+// Existing provider is used when p is nil, which is useful for providers that refresh their own values
+func (r *Ruleset) ReloadPlaceholders(p PlaceholderProvider) ReloadStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p == nil {
+		p = r.placeholders
+	}
+	next, stats := buildSnapshot(r.handles, p, r.placeholderPolicy, r.Snapshot())
+	r.placeholders = p
+	r.store(next)
+	return stats
+}
+
+// buildSnapshot compiles rules into a new snapshot
+// Rules that are unchanged since previous snapshot are reused, so their aggregation state is kept
+func buildSnapshot(
+	rules []RuleHandle,
+	placeholders PlaceholderProvider,
+	policy PlaceholderPolicy,
+	previous *RulesetSnapshot,
+) (*RulesetSnapshot, ReloadStats) {
 	var fail, unsupp int
 	set := make([]*Tree, 0)
+	correlations := make([]*Correlator, 0)
+	reuse := newReusableTrees(previous)
 loop:
 	for _, raw := range rules {
-		if raw.Multipart {
-			unsupp++
+		if raw.Correlation != nil {
+			c, err := NewCorrelator(raw)
+			if err != nil {
+				fail++
+				continue loop
+			}
+			correlations = append(correlations, c)
+			continue loop
+		}
+		raw, err := raw.ExpandPlaceholders(placeholders)
+		if err != nil {
+			if policy == PlaceholderDrop {
+				unsupp++
+			} else {
+				fail++
+			}
+			continue loop
+		}
+		if tree, ok := reuse.take(&raw); ok {
+			set = append(set, tree)
 			continue loop
 		}
 		tree, err := NewTree(raw)
@@ -93,27 +251,149 @@ loop:
 		}
 		set = append(set, tree)
 	}
-	return &Ruleset{
-		mu:          &sync.RWMutex{},
-		Rules:       set,
-		Failed:      fail,
-		Ok:          len(set),
-		Unsupported: unsupp,
-		Total:       len(rules),
+	stats := diffRules(previous, set, correlations)
+	correlations, failedCorrelations := resolveCorrelations(set, correlations)
+	fail += failedCorrelations
+	return &RulesetSnapshot{
+		Rules:        set,
+		Correlations: correlations,
+		suppressed:   suppressedByCorrelations(correlations),
+		prefilter:    newPrefilter(set),
+		logsources:   newLogsourceIndex(set),
+		Failed:       fail,
+		Ok:           len(set) + len(correlations),
+		Unsupported:  unsupp,
+		Total:        len(rules),
+	}, stats
+}
+
+// resolveCorrelations maps correlation rule references to rule IDs
+// returns correlations that could be resolved in evaluation order, along with failure count
+func resolveCorrelations(rules []*Tree, correlations []*Correlator) ([]*Correlator, int) {
+	if len(correlations) == 0 {
+		return correlations, 0
+	}
+	// documents of multipart rule share id, so results of any of them count for the referenced rule
+	lookup := make(map[string]string)
+	add := func(r *RuleHandle) {
+		if r.ID == "" {
+			return
+		}
+		lookup[r.ID] = r.ID
+		if r.Name != "" {
+			lookup[r.Name] = r.ID
+		}
+	}
+	for _, r := range rules {
+		add(r.Rule)
+	}
+	for _, c := range correlations {
+		add(c.Rule)
+	}
+	resolved := make([]*Correlator, 0, len(correlations))
+	for _, c := range correlations {
+		if err := c.resolve(lookup); err != nil {
+			continue
+		}
+		resolved = append(resolved, c)
+	}
+	sorted, cyclic := sortCorrelators(resolved)
+	return sorted, len(correlations) - len(resolved) + cyclic
+}
+
+// suppressedByCorrelations returns IDs of rules that are only referenced by
+// correlations that do not generate results for referenced rules
+func suppressedByCorrelations(correlations []*Correlator) map[string]bool {
+	suppressed := make(map[string]bool)
+	generated := make(map[string]bool)
+	for _, c := range correlations {
+		for id := range c.refs {
+			if c.Rule.Correlation.Generate {
+				generated[id] = true
+			} else {
+				suppressed[id] = true
+			}
+		}
+	}
+	for id := range generated {
+		delete(suppressed, id)
 	}
+	return suppressed
 }
 
+// EvalAll evaluates event against all rules and correlations in ruleset
+// If Config.RouteLogsource is set, events that implement Logsourcer are only evaluated against rules for their logsource
 func (r *Ruleset) EvalAll(e Event) (Results, bool) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	return r.Snapshot().EvalAll(e)
+}
+
+// EvalLogsource evaluates event against rules that were written for logsource ls, and all correlations
+// Rules with empty logsource fields match any value, as do empty fields of ls
+func (r *Ruleset) EvalLogsource(e Event, ls Logsource) (Results, bool) {
+	return r.Snapshot().EvalLogsource(e, ls)
+}
+
+// EvalAll evaluates event against all rules and correlations in snapshot
+// If Config.RouteLogsource is set, events that implement Logsourcer are only evaluated against rules for their logsource
+func (s *RulesetSnapshot) EvalAll(e Event) (Results, bool) {
+	if l, ok := e.(Logsourcer); ok && s.routeLogsource {
+		if ls, ok := l.Logsource(); ok {
+			return s.EvalLogsource(e, ls)
+		}
+	}
+	return s.eval(e, nil)
+}
+
+// EvalLogsource evaluates event against rules in snapshot that were written for logsource ls, and all correlations
+func (s *RulesetSnapshot) EvalLogsource(e Event, ls Logsource) (Results, bool) {
+	return s.eval(e, &ls)
+}
+
+func (s *RulesetSnapshot) eval(e Event, ls *Logsource) (Results, bool) {
 	results := make(Results, 0)
-	for _, rule := range r.Rules {
+	var routed []bool
+	if ls != nil {
+		routed = s.logsources.candidates(s.Rules, *ls)
+	}
+	// rules that can not match are skipped, nil means that all rules are evaluated
+	candidates := s.prefilter.candidates(s.Rules, e)
+	for i, rule := range s.Rules {
+		if routed != nil && !routed[i] {
+			continue
+		}
+		if ls != nil && routed == nil && rule.Rule != nil && !rule.Rule.Logsource.Matches(*ls) {
+			continue
+		}
+		if candidates != nil && !candidates[i] {
+			continue
+		}
 		if res, match := rule.Eval(e); match {
 			results = append(results, *res)
 		}
 	}
+	if len(results) > 0 {
+		for _, c := range s.Correlations {
+			if res, match := c.Eval(e, results); match {
+				results = append(results, *res)
+			}
+		}
+	}
+	if len(s.suppressed) > 0 {
+		filtered := results[:0]
+		for _, res := range results {
+			if !s.suppressed[res.ID] {
+				filtered = append(filtered, res)
+			}
+		}
+		results = filtered
+	}
 	if len(results) > 0 {
 		return results, true
 	}
 	return nil, false
 }
+
+// sameRules reports whether a and b are the same rule list, used to detect indexes that are out of date
+func sameRules(a, b []*Tree) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
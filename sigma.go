@@ -1,5 +1,7 @@
 package sigma
 
+import "time"
+
 // Keyworder implements keywords sigma rule type on arbitrary event
 // Should return list of fields that are relevant for rule matching
 type Keyworder interface {
@@ -20,6 +22,14 @@ type Event interface {
 	Selector
 }
 
+// Timestamper is an optional interface for events that carry their own timestamp
+// Stateful rules, such as aggregations, use it to place events in a sliding window
+// Wall clock time is used for events that do not implement it
+type Timestamper interface {
+	// Timestamp implements Timestamper
+	Timestamp() (time.Time, bool)
+}
+
 // Matcher is used for implementing Abstract Syntax Tree for Sigma engine
 type Matcher interface {
 	// Match implements Matcher
@@ -29,8 +39,5 @@ type Matcher interface {
 // Branch implements Matcher with additional methods for walking and debugging the tree
 type Branch interface {
 	Matcher
-
-	// Self returns Node or final rule object for debugging and/or walking the tree
-	// Must be type switched externally
-	// Self() interface{}
+	Node
 }
@@ -1,8 +1,6 @@
 package sigma
 
 import (
-	"context"
-
 	"github.com/gobwas/glob"
 )
 
@@ -11,8 +9,10 @@ var eof = rune(0)
 // Item is lexical token along with respective plaintext value
 // Item is communicated between lexer and parser
 type Item struct {
-	T            Token
-	Val          string
+	T   Token
+	Val string
+	// Pos is byte offset of item in condition
+	Pos          int
 	globVal      *glob.Glob // Do NOT access directly, us the Item.Glob() function instead
 	globCompFail bool       // prevents us from trying to re-compile a failed globVal over and over...
 }
@@ -35,17 +35,6 @@ func (i *Item) Glob() *glob.Glob {
 	return i.globVal
 }
 
-func genItems(t []Item) <-chan Item {
-	tx := make(chan Item) // unbuffered
-	go func(ctx context.Context) {
-		defer close(tx)
-		for _, item := range t {
-			tx <- item
-		}
-	}(context.TODO())
-	return tx
-}
-
 // Token is a lexical token extracted from condition field
 type Token int
 
@@ -90,6 +79,9 @@ const (
 	// Statements
 	TokStOne
 	TokStAll
+
+	// New tokens are appended, so that values of existing tokens stay stable
+	TokLitNum
 )
 
 // String documents human readable textual value of token
@@ -130,8 +122,14 @@ func (t Token) String() string {
 		return "ONE"
 	case TokKeywordAgg:
 		return "AGG"
+	case TokKeywordBy:
+		return "BY"
+	case TokKeywordNear:
+		return "NEAR"
 	case TokLitEof:
 		return "EOF"
+	case TokLitNum:
+		return "NUM"
 	case TokErr:
 		return "ERR"
 	case TokUnsupp:
@@ -175,6 +173,10 @@ func (t Token) Literal() string {
 		return "or"
 	case TokKeywordNot:
 		return "not"
+	case TokKeywordBy:
+		return "by"
+	case TokKeywordNear:
+		return "near"
 	case TokStAll:
 		return "all of"
 	case TokStOne:
@@ -195,60 +197,41 @@ func (t Token) Rune() rune {
 		return ')'
 	case TokSepPipe:
 		return '|'
+	case TokOpEq:
+		return '='
+	case TokOpGt:
+		return '>'
+	case TokOpLt:
+		return '<'
 	default:
 		return eof
 	}
 }
 
-// validTokenSequence detects invalid token sequences
-// not meant to be a perfect validator, simply a quick check before parsing
-func validTokenSequence(t1, t2 Token) bool {
+// validAggTokenSequence detects invalid token sequences in an aggregation expression
+// that follows the pipe separator
+// count(field) by group > N
+func validAggTokenSequence(t1, t2 Token) bool {
 	switch t2 {
-	case TokStAll, TokStOne:
-		switch t1 {
-		case TokBegin, TokSepLpar, TokKeywordAnd, TokKeywordOr, TokKeywordNot:
-			return true
-		}
-	case TokIdentifierAll:
-		switch t1 {
-		case TokStAll, TokStOne:
-			return true
-		}
-	case TokIdentifier, TokIdentifierWithWildcard:
-		switch t1 {
-		case TokSepLpar, TokBegin, TokKeywordAnd, TokKeywordOr, TokKeywordNot, TokStOne, TokStAll:
-			return true
-		}
-	case TokKeywordAnd, TokKeywordOr:
-		switch t1 {
-		case TokIdentifier, TokIdentifierAll, TokIdentifierWithWildcard, TokSepRpar:
-			return true
-		}
-	case TokKeywordNot:
-		switch t1 {
-		case TokKeywordAnd, TokKeywordOr, TokSepLpar, TokBegin:
-			return true
-		}
+	case TokKeywordAgg:
+		return t1 == TokSepPipe
 	case TokSepLpar:
-		switch t1 {
-		case TokKeywordAnd, TokKeywordOr, TokKeywordNot, TokBegin, TokSepLpar:
-			return true
-		}
+		return t1 == TokKeywordAgg
 	case TokSepRpar:
+		return t1 == TokSepLpar || t1 == TokIdentifier
+	case TokIdentifier:
+		return t1 == TokSepLpar || t1 == TokKeywordBy
+	case TokKeywordBy:
+		return t1 == TokSepRpar
+	case TokOpEq, TokOpGt, TokOpGte, TokOpLt, TokOpLte:
+		return t1 == TokSepRpar || t1 == TokIdentifier
+	case TokLitNum:
 		switch t1 {
-		case TokIdentifier, TokIdentifierAll, TokIdentifierWithWildcard, TokSepLpar, TokSepRpar:
+		case TokOpEq, TokOpGt, TokOpGte, TokOpLt, TokOpLte:
 			return true
 		}
 	case TokLitEof:
-		switch t1 {
-		case TokIdentifier, TokIdentifierAll, TokIdentifierWithWildcard, TokSepRpar:
-			return true
-		}
-	case TokSepPipe:
-		switch t1 {
-		case TokIdentifier, TokIdentifierAll, TokIdentifierWithWildcard, TokSepRpar:
-			return true
-		}
+		return t1 == TokLitNum
 	}
 	return false
 }
@@ -1,7 +1,10 @@
 package sigma
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/gobwas/glob"
 )
@@ -10,18 +13,28 @@ import (
 type Tree struct {
 	Root Branch
 	Rule *RuleHandle
+
+	// Agg is stateful aggregation expression that follows the pipe separator in condition
+	// nil if rule has none
+	Agg *Aggregation
 }
 
 // Match implements Matcher
+// Only evaluates rule detection, aggregation state is not touched
 func (t Tree) Match(e Event) (bool, bool) {
 	return t.Root.Match(e)
 }
 
+// Eval evaluates the event against rule and returns a result on positive match
+// Rules with an aggregation only return a result when the aggregation condition holds
 func (t Tree) Eval(e Event) (*Result, bool) {
 	match, applicable := t.Match(e)
 	if !applicable {
 		return nil, false
 	}
+	if match && t.Agg != nil && !t.Agg.Update(e) {
+		return nil, false
+	}
 	if t.Rule == nil && match {
 		return &Result{}, true
 	}
@@ -46,172 +59,72 @@ func NewTree(r RuleHandle) (*Tree, error) {
 		return nil, ErrMissingCondition{}
 	}
 
+	var timeframe time.Duration
+	if val, ok := r.Detection["timeframe"]; ok {
+		tf, err := parseTimeframe(fmt.Sprintf("%v", val))
+		if err != nil {
+			return nil, err
+		}
+		timeframe = tf
+	}
+
 	p := &parser{
 		lex:          lex(expr),
 		condition:    expr,
 		sigma:        r.Detection,
 		noCollapseWS: r.NoCollapseWS,
+		timeframe:    timeframe,
+		transforms:   newRuleTransformations(r.Pipelines, r.Logsource),
 	}
 	if err := p.run(); err != nil {
-		return nil, err
-	}
-	t := &Tree{
-		Root: p.result,
-		Rule: &r,
-	}
-	return t, nil
-}
-
-// newBranch builds a binary tree from token list
-// sequence and group validation should be done before invoking newBranch
-func newBranch(d Detection, t []Item, depth int, noCollapseWS bool) (Branch, error) {
-	rx := genItems(t)
-
-	and := make(NodeSimpleAnd, 0)
-	or := make(NodeSimpleOr, 0)
-	var negated bool
-	var wildcard Token
-
-	for item := range rx {
-		switch item.T {
-		case TokIdentifier:
-			val, ok := d[item.Val]
-			if !ok {
-				return nil, ErrMissingConditionItem{Key: item.Val}
-			}
-			b, err := newRuleFromIdent(val, checkIdentType(item.Val, val), noCollapseWS)
-			if err != nil {
-				return nil, err
-			}
-			and = append(and, newNodeNotIfNegated(b, negated))
-			negated = false
-		case TokKeywordAnd:
-			// no need to do anything special here
-		case TokKeywordOr:
-			// fill OR gate with collected AND nodes
-			// reduce will strip AND logic if only one token has been collected
-			or = append(or, and.Reduce())
-			// reset existing AND collector
-			and = make(NodeSimpleAnd, 0)
-		case TokKeywordNot:
-			negated = true
-		case TokSepLpar:
-			// recursively create new branch and append to existing list
-			// then skip to next token after grouping
-			b, err := newBranch(d, extractGroup(rx), depth+1, noCollapseWS)
-			if err != nil {
-				return nil, err
-			}
-			and = append(and, newNodeNotIfNegated(b, negated))
-			negated = false
-		case TokIdentifierAll:
-			switch wildcard {
-			case TokStAll:
-				rules, err := extractAllToRules(d, noCollapseWS)
-				if err != nil {
-					return nil, err
-				}
-				and = append(and, newNodeNotIfNegated(NodeSimpleAnd(rules), negated))
-				negated = false
-			case TokStOne:
-				rules, err := extractAllToRules(d, noCollapseWS)
-				if err != nil {
-					return nil, err
-				}
-				and = append(and, newNodeNotIfNegated(NodeSimpleOr(rules), negated))
-				negated = false
-			default:
-				return nil, fmt.Errorf("invalid wildcard ident, missing 1 of/ all of prefix")
-			}
-		case TokIdentifierWithWildcard:
-			switch wildcard {
-			case TokStAll:
-				// build logical conjunction
-				rules, err := extractAndBuildBranches(d, item.Glob(), noCollapseWS)
-				if err != nil {
-					return nil, fmt.Errorf("failed to extract and build branch for '%s': %s", item, err)
-				}
-				and = append(and, newNodeNotIfNegated(NodeSimpleAnd(rules), negated))
-				negated = false
-			case TokStOne:
-				// build logical disjunction
-				rules, err := extractAndBuildBranches(d, item.Glob(), noCollapseWS)
-				if err != nil {
-					return nil, fmt.Errorf("failed to extract and build branch for '%s': %s", item, err)
-				}
-				and = append(and, newNodeNotIfNegated(NodeSimpleOr(rules), negated))
-				negated = false
-			default:
-				// invalid case, did not see 1of/allof statement before wildcard ident
-				return nil, fmt.Errorf("invalid wildcard ident, missing 1 of/ all of prefix")
-			}
-			wildcard = TokBegin
-		case TokStAll:
-			wildcard = TokStAll
-		case TokStOne:
-			wildcard = TokStOne
-		case TokSepRpar:
-			return nil, fmt.Errorf("parser error, should not see %s",
-				TokSepRpar)
-		default:
-			return nil, ErrUnsupportedToken{
-				Msg: fmt.Sprintf("%s | %s", item.T, item.T.Literal()),
+		var re ErrInvalidRegex
+		if errors.As(err, &re) {
+			re.Rule = r.ID
+			if re.Rule == "" {
+				re.Rule = r.Title
 			}
+			return nil, re
 		}
-	}
-	or = append(or, newNodeNotIfNegated(and.Reduce(), negated))
-
-	return or.Reduce(), nil
-}
-
-func extractGroup(rx <-chan Item) []Item {
-	// fn is called when newBranch hits TokSepLpar
-	// it will be consumed, so balance is already 1
-	balance := 1
-	group := make([]Item, 0)
-	for item := range rx {
-		if balance > 0 {
-			group = append(group, item)
-		}
-		switch item.T {
-		case TokSepLpar:
-			balance++
-		case TokSepRpar:
-			balance--
-			if balance == 0 {
-				return group[:len(group)-1]
-			}
-		default:
-		}
-	}
-	return group
-}
-
-func extractAndBuildBranches(d Detection, g *glob.Glob, noCollapseWS bool) ([]Branch, error) {
-	vals, err := extractWildcardIdents(d, g)
-	if err != nil {
 		return nil, err
 	}
-	rules := make(NodeSimpleAnd, len(vals))
-	for i, v := range vals {
-		b, err := newRuleFromIdent(v, identSelection, noCollapseWS)
+	if p.agg != nil {
+		// aggregation fields are read from events, so they follow the same mapping as selections
+		p.agg.Field = p.transforms.fieldName(p.agg.Field)
+		p.agg.GroupBy = p.transforms.fieldName(p.agg.GroupBy)
+	}
+	// conditions added by pipelines must match along with rule detection
+	root := NodeSimpleAnd{}
+	for _, cond := range p.transforms.conditions() {
+		sel, err := newSelectionFromMap(cond, r.NoCollapseWS, nil)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("pipeline condition: %w", err)
 		}
-		rules[i] = b
+		root = append(root, sel)
 	}
-	return rules, nil
+	t := &Tree{
+		Root: append(root, p.result).Reduce(),
+		Rule: &r,
+		Agg:  p.agg,
+	}
+	return t, nil
 }
 
-func extractWildcardIdents(d Detection, g *glob.Glob) ([]interface{}, error) {
+func extractAndBuildBranches(d Detection, g *glob.Glob, noCollapseWS bool, transforms ruleTransformations) ([]Branch, error) {
 	if g == nil {
 		return nil, fmt.Errorf("passed glob was nil (failed to compile)")
 	}
-	rules := make([]interface{}, 0)
-	for k, v := range d {
-		if (*g).Match(k) {
-			rules = append(rules, v)
+	rules := make([]Branch, 0)
+	items := d.Extract()
+	for _, k := range sortedKeys(items) {
+		v := items[k]
+		if !(*g).Match(k) {
+			continue
 		}
+		b, err := newRuleFromIdent(v, checkIdentType(k, v), noCollapseWS, transforms)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, b)
 	}
 	if len(rules) == 0 {
 		return nil, fmt.Errorf("ident did not match any values")
@@ -219,10 +132,12 @@ func extractWildcardIdents(d Detection, g *glob.Glob) ([]interface{}, error) {
 	return rules, nil
 }
 
-func extractAllToRules(d Detection, noCollapseWS bool) ([]Branch, error) {
+func extractAllToRules(d Detection, noCollapseWS bool, transforms ruleTransformations) ([]Branch, error) {
 	rules := make([]Branch, 0)
-	for k, v := range d.Extract() {
-		b, err := newRuleFromIdent(v, checkIdentType(k, v), noCollapseWS)
+	items := d.Extract()
+	for _, k := range sortedKeys(items) {
+		v := items[k]
+		b, err := newRuleFromIdent(v, checkIdentType(k, v), noCollapseWS, transforms)
 		if err != nil {
 			return nil, err
 		}
@@ -230,3 +145,13 @@ func extractAllToRules(d Detection, noCollapseWS bool) ([]Branch, error) {
 	}
 	return rules, nil
 }
+
+// sortedKeys returns map keys in sorted order, so that rules are always built the same way
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
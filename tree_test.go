@@ -2,6 +2,7 @@ package sigma
 
 import (
 	"encoding/json"
+	"runtime"
 	"testing"
 
 	"github.com/markuskont/datamodels"
@@ -43,6 +44,66 @@ func TestTreeParse(t *testing.T) {
 	}
 }
 
+func TestTreeInvalidRegex(t *testing.T) {
+	rules := []string{`
+id: lookahead
+detection:
+  condition: selection
+  selection:
+    CommandLine|re: 'foo(?=bar)'
+`, `
+id: lookbehind
+detection:
+  condition: 1 of selection*
+  selection1:
+    CommandLine|re|i:
+    - 'foo'
+    - '(?<!bar)baz'
+`}
+	for i, raw := range rules {
+		var rule Rule
+		if err := yaml.Unmarshal([]byte(raw), &rule); err != nil {
+			t.Fatalf("invalid regex case %d failed to unmarshal yaml, %s", i, err)
+		}
+		_, err := NewTree(RuleHandle{Rule: rule})
+		re, ok := err.(ErrInvalidRegex)
+		if !ok {
+			t.Fatalf("invalid regex case %d should return ErrInvalidRegex, got %v", i, err)
+		}
+		if re.Rule != rule.ID || re.Field != "CommandLine" {
+			t.Fatalf("invalid regex case %d has wrong context: %s", i, re)
+		}
+	}
+}
+
+func TestNewTreeNoGoroutineLeak(t *testing.T) {
+	conditions := []string{
+		"selection | near filter",
+		"selection filter",
+		"selection and (filter",
+		"missing or selection",
+		"1 of nomatch*",
+		"selection | count() >> 10",
+		"selection | count(",
+	}
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		for _, condition := range conditions {
+			_, err := NewTree(RuleHandle{Rule: Rule{Detection: Detection{
+				"condition": condition,
+				"selection": map[interface{}]interface{}{"A": "x"},
+				"filter":    map[interface{}]interface{}{"B": "x"},
+			}}})
+			if err == nil {
+				t.Fatalf("condition %s should fail to parse", condition)
+			}
+		}
+	}
+	if after := runtime.NumGoroutine(); after != before {
+		t.Fatalf("goroutine count changed from %d to %d after failed parses", before, after)
+	}
+}
+
 // we should probably add an alternative to this benchmark to include noCollapseWS on or off (we collapse by default now)
 func benchmarkCase(b *testing.B, rawRule, rawEvent string) {
 	var rule Rule
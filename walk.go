@@ -0,0 +1,181 @@
+package sigma
+
+// Node is an element of compiled rule tree
+// Logic nodes, rule objects, selection items and patterns implement it
+// Metadata is exposed via exported fields, so Node must be type switched externally
+// Note that some nodes are stored as pointers, e.g. *NodeAnd, *Selection or *PrefixTrie
+type Node interface {
+	// Children returns nested nodes in evaluation order, nil for leaves
+	Children() []Node
+}
+
+// Visitor is called for every node when walking the tree
+type Visitor interface {
+	// Enter is called before visiting node children, returning false skips them
+	Enter(Node) bool
+	// Leave is called after node children have been visited
+	// Also called for nodes where Enter returned false
+	Leave(Node)
+}
+
+// Walk traverses tree depth first, starting from b
+// Custom StringMatcher, NumMatcher and NetMatcher implementations that do not implement Node are not visited
+func Walk(b Branch, v Visitor) {
+	walk(b, v)
+}
+
+func walk(n Node, v Visitor) {
+	if n == nil {
+		return
+	}
+	if v.Enter(n) {
+		for _, c := range n.Children() {
+			walk(c, v)
+		}
+	}
+	v.Leave(n)
+}
+
+// nodes converts arbitrary matchers to Node list, skipping those that do not implement it
+func nodes(matchers ...interface{}) []Node {
+	out := make([]Node, 0, len(matchers))
+	for _, m := range matchers {
+		if n, ok := m.(Node); ok && n != nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Children implements Node
+func (t Tree) Children() []Node { return nodes(t.Root) }
+
+// Children implements Node
+func (n NodeSimpleAnd) Children() []Node {
+	out := make([]Node, 0, len(n))
+	for _, b := range n {
+		out = append(out, b)
+	}
+	return out
+}
+
+// Children implements Node
+func (n NodeSimpleOr) Children() []Node {
+	out := make([]Node, 0, len(n))
+	for _, b := range n {
+		out = append(out, b)
+	}
+	return out
+}
+
+// Children implements Node
+func (n NodeNot) Children() []Node { return nodes(n.B) }
+
+// Children implements Node
+func (n NodeAnd) Children() []Node { return nodes(n.L, n.R) }
+
+// Children implements Node
+func (n NodeOr) Children() []Node { return nodes(n.L, n.R) }
+
+// Children implements Node
+func (k Keyword) Children() []Node { return nodes(k.S) }
+
+// Children implements Node
+// Items are returned in the same order as they are evaluated by Match
+func (s Selection) Children() []Node {
+	out := make([]Node, 0, len(s.E)+len(s.N)+len(s.S)+len(s.F)+len(s.B)+len(s.I))
+	for _, v := range s.E {
+		out = append(out, v)
+	}
+	for _, v := range s.N {
+		out = append(out, v)
+	}
+	for _, v := range s.S {
+		out = append(out, v)
+	}
+	for _, v := range s.F {
+		out = append(out, v)
+	}
+	for _, v := range s.B {
+		out = append(out, v)
+	}
+	for _, v := range s.I {
+		out = append(out, v)
+	}
+	return out
+}
+
+// Children implements Node
+func (s SelectionExistsItem) Children() []Node { return nil }
+
+// Children implements Node
+func (s SelectionNumItem) Children() []Node { return nodes(s.Pattern) }
+
+// Children implements Node
+func (s SelectionStringItem) Children() []Node { return nodes(s.Pattern) }
+
+// Children implements Node
+func (s SelectionFieldRefItem) Children() []Node { return nil }
+
+// Children implements Node
+func (s SelectionBoolItem) Children() []Node { return nil }
+
+// Children implements Node
+func (s SelectionNetItem) Children() []Node { return nodes(s.Pattern) }
+
+// Children implements Node
+func (l LowercasePattern) Children() []Node { return nodes(l.S) }
+
+// Children implements Node
+func (s StringMatchers) Children() []Node {
+	out := make([]Node, 0, len(s))
+	for _, m := range s {
+		out = append(out, nodes(m)...)
+	}
+	return out
+}
+
+// Children implements Node
+func (s StringMatchersConj) Children() []Node {
+	out := make([]Node, 0, len(s))
+	for _, m := range s {
+		out = append(out, nodes(m)...)
+	}
+	return out
+}
+
+// Children implements Node
+func (c ContentPattern) Children() []Node { return nil }
+
+// Children implements Node
+func (c PrefixPattern) Children() []Node { return nil }
+
+// Children implements Node
+func (c SuffixPattern) Children() []Node { return nil }
+
+// Children implements Node
+func (r RegexPattern) Children() []Node { return nil }
+
+// Children implements Node
+func (g GlobPattern) Children() []Node { return nil }
+
+// Children implements Node
+func (s SimplePattern) Children() []Node { return nil }
+
+// Children implements Node
+func (b BytePattern) Children() []Node { return nil }
+
+// Children implements Node
+func (n NumMatchers) Children() []Node {
+	out := make([]Node, 0, len(n))
+	for _, m := range n {
+		out = append(out, nodes(m)...)
+	}
+	return out
+}
+
+// Children implements Node
+func (n NumPattern) Children() []Node { return nil }
+
+// Children implements Node
+func (t PrefixTrie) Children() []Node { return nil }
@@ -0,0 +1,94 @@
+package sigma
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+var walkRule = `
+detection:
+  condition: selection and not filter or keywords
+  selection:
+    Image|endswith: '\cmd.exe'
+    EventID: 1
+  filter:
+    CommandLine|contains:
+      - 'foo'
+      - 'bar'
+  keywords:
+    - 'evil'
+`
+
+type recordingVisitor struct {
+	depth int
+	lines []string
+	skip  func(Node) bool
+}
+
+func (r *recordingVisitor) Enter(n Node) bool {
+	r.lines = append(r.lines, fmt.Sprintf("%s%T", strings.Repeat(" ", r.depth), n))
+	r.depth++
+	return r.skip == nil || !r.skip(n)
+}
+
+func (r *recordingVisitor) Leave(n Node) {
+	r.depth--
+}
+
+func TestWalk(t *testing.T) {
+	var rule Rule
+	if err := yaml.Unmarshal([]byte(walkRule), &rule); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTree(RuleHandle{Rule: rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &recordingVisitor{}
+	Walk(tree.Root, v)
+	expected := []string{
+		"*sigma.NodeOr",
+		" *sigma.NodeAnd",
+		"  *sigma.Selection",
+		"   sigma.SelectionNumItem",
+		"    sigma.NumPattern",
+		"   sigma.SelectionStringItem",
+		"    sigma.LowercasePattern",
+		"     sigma.SuffixPattern",
+		"  *sigma.NodeNot",
+		"   *sigma.Selection",
+		"    sigma.SelectionStringItem",
+		"     sigma.LowercasePattern",
+		"      sigma.StringMatchers",
+		"       sigma.GlobPattern",
+		"       sigma.GlobPattern",
+		" *sigma.Keyword",
+		"  sigma.LowercasePattern",
+		"   sigma.GlobPattern",
+	}
+	if got := strings.Join(v.lines, "\n"); got != strings.Join(expected, "\n") {
+		t.Fatalf("walk visited unexpected nodes, got:\n%s", got)
+	}
+	if v.depth != 0 {
+		t.Fatalf("walk did not leave every entered node, depth %d", v.depth)
+	}
+
+	// children of skipped nodes must not be visited
+	v = &recordingVisitor{skip: func(n Node) bool {
+		_, ok := n.(*Selection)
+		return ok
+	}}
+	Walk(tree, v)
+	for _, line := range v.lines {
+		if strings.Contains(line, "SelectionStringItem") {
+			t.Fatalf("walk entered children of skipped selection")
+		}
+	}
+	if v.lines[0] != "*sigma.Tree" || v.depth != 0 {
+		t.Fatalf("walk from tree visited unexpected nodes: %v", v.lines)
+	}
+}